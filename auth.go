@@ -0,0 +1,133 @@
+package mysqltest
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// Names of authentication plugins that can be used for
+// config.DefaultAuthPlugin and User.Plugin
+const (
+	AuthNativePassword      = "mysql_native_password"
+	AuthCachingSHA2Password = "caching_sha2_password"
+	AuthSHA256Password      = "sha256_password"
+	AuthSocket              = "auth_socket"
+)
+
+var serverPubKeyID int64
+
+// CreateUserWithPlugin creates a user that authenticates using the given
+// plugin, and returns a DSN to connect as that user. For AuthSocket,
+// name must be the name of the OS user running the tests, and password
+// is ignored. The plugin must be loaded (see config.PluginLoad)
+func (m *TestMysqld) CreateUserWithPlugin(name, password, plugin string, grants ...string) (string, error) {
+	if plugin == AuthSocket {
+		password = ""
+	}
+
+	return m.ProvisionUser(&User{
+		Name:     name,
+		Password: password,
+		Plugin:   plugin,
+		Grants:   grants,
+	})
+}
+
+// ResetAuthCache executes FLUSH PRIVILEGES, which, among other things,
+// clears the caching_sha2_password authentication cache. The next
+// login of each caching_sha2_password user goes through full
+// authentication instead of fast authentication
+func (m *TestMysqld) ResetAuthCache() error {
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("FLUSH PRIVILEGES"); err != nil {
+		return errors.Wrap(err, `failed to flush privileges`)
+	}
+	return nil
+}
+
+// RegisterServerPubKey fetches the RSA public key that the server uses
+// for caching_sha2_password (or sha256_password, on servers that do not
+// support the former), registers it with the mysql driver, and returns
+// the name to be passed to WithServerPubKey.
+//
+// Full authentication with these plugins over a connection without TLS
+// encrypts the password with this key. Without WithServerPubKey, the
+// driver requests the key from the server during each full
+// authentication. The key is deregistered by Stop
+func (m *TestMysqld) RegisterServerPubKey() (string, error) {
+	if m.serverPubKeyName != "" {
+		return m.serverPubKeyName, nil
+	}
+
+	db, err := m.openRoot()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var data string
+	for _, variable := range []string{"Caching_sha2_password_rsa_public_key", "Rsa_public_key"} {
+		var name string
+		err := db.QueryRow("SHOW STATUS LIKE '"+variable+"'").Scan(&name, &data)
+		if err != nil && err != sql.ErrNoRows {
+			return "", errors.Wrap(err, `failed to fetch server public key`)
+		}
+		if data != "" {
+			break
+		}
+	}
+	if data == "" {
+		return "", errors.New(`server does not have an RSA public key`)
+	}
+
+	key, err := parsePublicKey([]byte(data))
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("mysqltest-pubkey-%d", atomic.AddInt64(&serverPubKeyID, 1))
+	mysql.RegisterServerPubKey(name, key)
+	m.serverPubKeyName = name
+	return name, nil
+}
+
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(`failed to decode server public key`)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to parse server public key`)
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New(`server public key is not an RSA key`)
+	}
+	return key, nil
+}
+
+// defaultAuthPluginOption returns the my.cnf line that makes plugin the
+// default for new accounts. MySQL 8.4 removed default_authentication_plugin
+// in favor of authentication_policy, whose "*:plugin" form permits any
+// plugin while defaulting to the given one. The loose- prefix keeps
+// servers of unknown versions starting
+func defaultAuthPluginOption(version *serverVersion, plugin string) string {
+	if version != nil && !version.mariadb && version.atLeast(8, 4, 0) {
+		return "authentication_policy=*:" + plugin + ",,\n"
+	}
+	return "loose-default_authentication_plugin=" + plugin + "\n"
+}
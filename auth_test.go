@@ -0,0 +1,99 @@
+package mysqltest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthOptions(t *testing.T) {
+	dsn := Datasource(WithAllowNativePasswords(false), WithAllowCleartextPasswords(true), WithServerPubKey("mykey"))
+	for _, re := range []string{"allowNativePasswords=false", "allowCleartextPasswords=true", "serverPubKey=mykey"} {
+		if !assert.Regexp(t, re, dsn, "dsn matches expected") {
+			return
+		}
+	}
+}
+
+func TestDefaultAuthPluginOption(t *testing.T) {
+	testcases := []struct {
+		version  *serverVersion
+		expected string
+	}{
+		{version: nil, expected: "loose-default_authentication_plugin=mysql_native_password\n"},
+		{version: &serverVersion{major: 8, minor: 0, patch: 32}, expected: "loose-default_authentication_plugin=mysql_native_password\n"},
+		{version: &serverVersion{major: 8, minor: 4, patch: 0}, expected: "authentication_policy=*:mysql_native_password,,\n"},
+		{version: &serverVersion{major: 11, minor: 4, patch: 2, mariadb: true}, expected: "loose-default_authentication_plugin=mysql_native_password\n"},
+	}
+
+	for i, tc := range testcases {
+		if !assert.Equal(t, tc.expected, defaultAuthPluginOption(tc.version, AuthNativePassword), "option matches for case %d", i) {
+			return
+		}
+	}
+}
+
+func TestCachingSHA2Password(t *testing.T) {
+	config := NewConfig()
+	config.SkipNetworking = false
+	config.DefaultAuthPlugin = AuthCachingSHA2Password
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	if _, err := mysqld.CreateUserWithPlugin("sha2", "passw0rd", AuthCachingSHA2Password, "ALL ON test.*"); !assert.NoError(t, err, "CreateUserWithPlugin should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, mysqld.ResetAuthCache(), "ResetAuthCache should succeed") {
+		return
+	}
+
+	key, err := mysqld.RegisterServerPubKey()
+	if !assert.NoError(t, err, "RegisterServerPubKey should succeed") {
+		return
+	}
+
+	// A key that does not belong to the server, with which full
+	// authentication fails, but fast authentication does not care
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if !assert.NoError(t, err, "GenerateKey should succeed") {
+		return
+	}
+	mysql.RegisterServerPubKey("mysqltest-other", &other.PublicKey)
+	defer mysql.DeregisterServerPubKey("mysqltest-other")
+
+	ping := func(key string) error {
+		db, err := sql.Open("mysql", mysqld.UserDSN("sha2", WithServerPubKey(key)))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	}
+
+	if !assert.Error(t, ping("mysqltest-other"), "full authentication should fail with the wrong key") {
+		return
+	}
+	if !assert.NoError(t, ping(key), "full authentication should succeed with the server key") {
+		return
+	}
+	if !assert.NoError(t, ping("mysqltest-other"), "fast authentication should not need the key") {
+		return
+	}
+
+	// Full authentication starts over once the cache is cleared
+	if !assert.NoError(t, mysqld.ResetAuthCache(), "ResetAuthCache should succeed") {
+		return
+	}
+	if !assert.Error(t, ping("mysqltest-other"), "full authentication should fail with the wrong key") {
+		return
+	}
+}
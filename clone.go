@@ -0,0 +1,375 @@
+package mysqltest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CloneStrategy describes how a file was cloned
+type CloneStrategy string
+
+// Strategies used by Clone, in the order they are tried
+const (
+	// CloneReflink shares the data blocks with the source via the
+	// FICLONE ioctl (btrfs, XFS), until either file is modified
+	CloneReflink CloneStrategy = "reflink"
+
+	// CloneCopyFileRange copies the data inside the kernel via
+	// copy_file_range(2)
+	CloneCopyFileRange CloneStrategy = "copy_file_range"
+
+	// CloneCopy copies the data through userspace, like Dircopy
+	CloneCopy CloneStrategy = "copy"
+)
+
+// CloneOptions controls the behavior of Clone
+type CloneOptions struct {
+	// Workers is the number of files copied in parallel. Defaults to
+	// the number of CPUs
+	Workers int
+}
+
+// CloneReport describes what Clone did
+type CloneReport struct {
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+
+	// Strategies holds the number of files cloned with each strategy
+	Strategies map[CloneStrategy]int
+}
+
+// Strategy returns the strategy that was used for most files, or an
+// empty string if no files were cloned
+func (r *CloneReport) Strategy() CloneStrategy {
+	var strategy CloneStrategy
+	max := 0
+	for _, s := range []CloneStrategy{CloneReflink, CloneCopyFileRange, CloneCopy} {
+		if n := r.Strategies[s]; n > max {
+			strategy, max = s, n
+		}
+	}
+	return strategy
+}
+
+type cloneJob struct {
+	src  string
+	dst  string
+	info os.FileInfo
+}
+
+type cloneDir struct {
+	path string
+	info os.FileInfo
+}
+
+type cloner struct {
+	options CloneOptions
+
+	mu       sync.Mutex
+	err      error
+	report   *CloneReport
+	disabled map[CloneStrategy]bool
+}
+
+// Clone copies the directory from into the directory to like Dircopy,
+// but as fast as the filesystem allows: data is shared via reflinks
+// when possible, otherwise files are copied in parallel, inside the
+// kernel when supported. Strategies that turn out to be unsupported
+// are not tried again for the remaining files. Files are never hard
+// linked: a hard link is the same file, not a copy, so every write
+// mysqld makes to its tablespaces and logs would end up in the source,
+// and which files mysqld writes to cannot be known in advance. opts
+// may be nil
+func Clone(from, to string, opts *CloneOptions) (*CloneReport, error) {
+	start := time.Now()
+
+	c := &cloner{
+		report:   &CloneReport{Strategies: make(map[CloneStrategy]int)},
+		disabled: make(map[CloneStrategy]bool),
+	}
+	if opts != nil {
+		c.options = *opts
+	}
+	if c.options.Workers <= 0 {
+		c.options.Workers = runtime.NumCPU()
+	}
+
+	info, err := os.Stat(from)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to stat %s`, from)
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf(`%s is not a directory`, from)
+	}
+
+	jobs := make(chan cloneJob)
+	var wg sync.WaitGroup
+	for i := 0; i < c.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if c.failed() {
+					continue
+				}
+				c.cloneFile(job)
+			}
+		}()
+	}
+
+	var dirs []cloneDir
+	c.walk(from, to, info, jobs, &dirs)
+	close(jobs)
+	wg.Wait()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	// Writing files changes the modification time of their directory,
+	// so directories are finished last, children before parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyMetadata(dirs[i].path, dirs[i].info); err != nil {
+			return nil, err
+		}
+	}
+
+	c.report.Duration = time.Since(start)
+	return c.report, nil
+}
+
+func (c *cloner) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+func (c *cloner) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *cloner) isDisabled(s CloneStrategy) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.disabled[s]
+}
+
+func (c *cloner) disable(s CloneStrategy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disabled[s] = true
+}
+
+// walk creates directories and symbolic links, and hands regular files
+// to the workers
+func (c *cloner) walk(src, dst string, info os.FileInfo, jobs chan<- cloneJob, dirs *[]cloneDir) {
+	if err := prepareDest(dst, os.ModeDir); err != nil {
+		c.fail(err)
+		return
+	}
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		c.fail(errors.Wrapf(err, `failed to create directory %s`, dst))
+		return
+	}
+	if err := os.Chmod(dst, 0700); err != nil {
+		c.fail(errors.Wrapf(err, `failed to change permissions of %s`, dst))
+		return
+	}
+	*dirs = append(*dirs, cloneDir{path: dst, info: info})
+
+	f, err := os.Open(src)
+	if err != nil {
+		c.fail(errors.Wrapf(err, `failed to read directory %s`, src))
+		return
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		c.fail(errors.Wrapf(err, `failed to read directory %s`, src))
+		return
+	}
+
+	for _, entry := range entries {
+		if c.failed() {
+			return
+		}
+
+		s := filepath.Join(src, entry.Name())
+		d := filepath.Join(dst, entry.Name())
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			c.walk(s, d, entry, jobs, dirs)
+		case mode&os.ModeSymlink != 0:
+			if err := copySymlink(s, d, entry); err != nil {
+				c.fail(err)
+			}
+		case mode.IsRegular():
+			jobs <- cloneJob{src: s, dst: d, info: entry}
+		default:
+			// Sockets, named pipes and devices cannot be copied
+		}
+	}
+}
+
+func (c *cloner) cloneFile(job cloneJob) {
+	if err := prepareDest(job.dst, 0); err != nil {
+		c.fail(err)
+		return
+	}
+
+	strategy, err := c.cloneContents(job.src, job.dst, job.info)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	if err := copyMetadata(job.dst, job.info); err != nil {
+		c.fail(err)
+		return
+	}
+
+	c.mu.Lock()
+	c.report.Files++
+	c.report.Bytes += job.info.Size()
+	c.report.Strategies[strategy]++
+	c.mu.Unlock()
+}
+
+func (c *cloner) cloneContents(src, dst string, info os.FileInfo) (CloneStrategy, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", errors.Wrapf(err, `failed to open %s`, src)
+	}
+	defer in.Close()
+
+	// finish closes out, and reports the first error
+	finish := func(out *os.File, strategy CloneStrategy, err error) (CloneStrategy, error) {
+		cerr := out.Close()
+		if err != nil {
+			return "", errors.Wrapf(err, `failed to copy %s to %s`, src, dst)
+		}
+		if cerr != nil {
+			return "", errors.Wrapf(cerr, `failed to close %s`, dst)
+		}
+		return strategy, nil
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", errors.Wrapf(err, `failed to create %s`, dst)
+	}
+
+	if !c.isDisabled(CloneReflink) {
+		err := reflink(out, in)
+		if err == nil {
+			return finish(out, CloneReflink, nil)
+		}
+		switch {
+		case isUnsupported(err):
+			c.disable(CloneReflink)
+		case !isUnsupportedFor(err):
+			return finish(out, "", err)
+		}
+	}
+
+	// copy_file_range may fill holes, so sparse files go through
+	// the userspace copy which preserves them
+	if !c.isDisabled(CloneCopyFileRange) && !isSparse(info) {
+		err := copyFileRange(out, in, info.Size())
+		if err == nil {
+			return finish(out, CloneCopyFileRange, nil)
+		}
+		switch {
+		case isUnsupported(err):
+			c.disable(CloneCopyFileRange)
+		case !isUnsupportedFor(err):
+			return finish(out, "", err)
+		}
+
+		// Start over in case some data was copied
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return finish(out, "", err)
+		}
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return finish(out, "", err)
+		}
+		if err := out.Truncate(0); err != nil {
+			return finish(out, "", err)
+		}
+	}
+
+	return finish(out, CloneCopy, copySparse(out, in))
+}
+
+// isSparse returns true if the file uses fewer blocks than its size
+func isSparse(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return int64(st.Blocks)*512 < info.Size()
+}
+
+// unsupportedErrnos are the errors that mean that the filesystem or the
+// kernel cannot perform an operation at all
+var unsupportedErrnos = []syscall.Errno{
+	syscall.EOPNOTSUPP,
+	syscall.ENOTSUP, // same as EOPNOTSUPP on some systems
+	syscall.EXDEV,
+	syscall.ENOSYS,
+	syscall.ENOTTY,
+}
+
+// isUnsupported returns true if err means that the strategy cannot be
+// used, so another one should be tried
+func isUnsupported(err error) bool {
+	e, ok := errno(err)
+	if !ok {
+		return false
+	}
+
+	for _, n := range unsupportedErrnos {
+		if e == n {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnsupportedFor returns true if err means that the strategy cannot
+// be used for this file, such as EPERM from copy_file_range under a
+// seccomp filter, or EINVAL on filesystems that only support it for
+// some files. The file falls back to the next strategy, which is still
+// tried for the remaining files
+func isUnsupportedFor(err error) bool {
+	e, ok := errno(err)
+	return ok && (e == syscall.EPERM || e == syscall.EINVAL)
+}
+
+// errno extracts the system error number from err, if any
+func errno(err error) (syscall.Errno, bool) {
+	switch e := errors.Cause(err).(type) {
+	case syscall.Errno:
+		return e, true
+	case *os.PathError:
+		return errno(e.Err)
+	case *os.LinkError:
+		return errno(e.Err)
+	case *os.SyscallError:
+		return errno(e.Err)
+	}
+	return 0, false
+}
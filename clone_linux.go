@@ -0,0 +1,33 @@
+package mysqltest
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink makes out share the data blocks of in
+func reflink(out, in *os.File) error {
+	return unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+}
+
+// copyFileRange copies size bytes from in to out inside the kernel
+func copyFileRange(out, in *os.File, size int64) error {
+	const chunk = 1 << 30
+	for size > 0 {
+		n := size
+		if n > chunk {
+			n = chunk
+		}
+
+		written, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, int(n), 0)
+		if err != nil {
+			return err
+		}
+		if written == 0 { // the source was truncated
+			break
+		}
+		size -= int64(written)
+	}
+	return nil
+}
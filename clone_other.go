@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package mysqltest
+
+import (
+	"os"
+	"syscall"
+)
+
+func reflink(out, in *os.File) error {
+	return syscall.ENOTSUP
+}
+
+func copyFileRange(out, in *os.File, size int64) error {
+	return syscall.ENOTSUP
+}
@@ -0,0 +1,128 @@
+package mysqltest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-clone")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	contents := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		name := filepath.Join(fmt.Sprintf("db%d", i%3), fmt.Sprintf("t%d.ibd", i))
+		content := bytes.Repeat([]byte{byte(i + 1)}, 10000*(i+1))
+		contents[name] = content
+
+		path := filepath.Join(src, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0750), "MkdirAll should succeed") {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(path, content, 0640), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	// A read-only file, which must not be hard linked
+	contents["auto.cnf"] = []byte("[auto]\n")
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "auto.cnf"), contents["auto.cnf"], 0444), "WriteFile should succeed") {
+		return
+	}
+
+	for _, opts := range []*CloneOptions{nil, {Workers: 1}} {
+		dst := filepath.Join(dir, fmt.Sprintf("dst-%v", opts != nil))
+		report, err := Clone(src, dst, opts)
+		if !assert.NoError(t, err, "Clone should succeed") {
+			return
+		}
+		t.Logf("cloned %d files (%d bytes) in %s using %s", report.Files, report.Bytes, report.Duration, report.Strategy())
+
+		if !assert.Equal(t, len(contents), report.Files, "all files are reported") {
+			return
+		}
+		var total int
+		for _, n := range report.Strategies {
+			total += n
+		}
+		if !assert.Equal(t, report.Files, total, "every file has a strategy") {
+			return
+		}
+
+		for name, expected := range contents {
+			content, err := ioutil.ReadFile(filepath.Join(dst, name))
+			if !assert.NoError(t, err, "ReadFile should succeed for %s", name) {
+				return
+			}
+			if !assert.True(t, bytes.Equal(expected, content), "content of %s matches", name) {
+				return
+			}
+		}
+
+		fi, err := os.Stat(filepath.Join(dst, "db0"))
+		if !assert.NoError(t, err, "Stat should succeed") {
+			return
+		}
+		if !assert.Equal(t, os.ModeDir|0750, fi.Mode(), "directory mode is preserved") {
+			return
+		}
+
+		srcInfo, err := os.Stat(filepath.Join(src, "auto.cnf"))
+		if !assert.NoError(t, err, "Stat should succeed") {
+			return
+		}
+		dstInfo, err := os.Stat(filepath.Join(dst, "auto.cnf"))
+		if !assert.NoError(t, err, "Stat should succeed") {
+			return
+		}
+		if !assert.False(t, os.SameFile(srcInfo, dstInfo), "read-only file is copied") {
+			return
+		}
+	}
+}
+
+func TestCloneReportStrategy(t *testing.T) {
+	r := &CloneReport{Strategies: map[CloneStrategy]int{CloneCopy: 3, CloneCopyFileRange: 1}}
+	if !assert.Equal(t, CloneCopy, r.Strategy(), "most used strategy") {
+		return
+	}
+
+	r = &CloneReport{Strategies: map[CloneStrategy]int{}}
+	if !assert.Equal(t, CloneStrategy(""), r.Strategy(), "no strategy without files") {
+		return
+	}
+}
+
+func TestIsUnsupported(t *testing.T) {
+	for _, e := range []syscall.Errno{syscall.EOPNOTSUPP, syscall.ENOTSUP, syscall.EXDEV, syscall.ENOSYS, syscall.ENOTTY} {
+		if !assert.True(t, isUnsupported(&os.PathError{Op: "clone", Path: "f", Err: e}), "%s means unsupported", e) {
+			return
+		}
+	}
+	for _, e := range []syscall.Errno{syscall.EPERM, syscall.EINVAL, syscall.EIO, syscall.ENOSPC} {
+		if !assert.False(t, isUnsupported(e), "%s does not disable the strategy", e) {
+			return
+		}
+	}
+	for _, e := range []syscall.Errno{syscall.EPERM, syscall.EINVAL} {
+		if !assert.True(t, isUnsupportedFor(&os.SyscallError{Syscall: "copy_file_range", Err: e}), "%s falls back for the file", e) {
+			return
+		}
+	}
+	for _, e := range []syscall.Errno{syscall.EIO, syscall.ENOSPC} {
+		if !assert.False(t, isUnsupportedFor(e), "%s is reported", e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package mysqltest
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Blocks of zeros of this size are turned into holes by Dircopy
+const sparseBlockSize = 4096
+
+// Dircopy recursively copies the contents of the directory from into
+// the directory to, which may already exist. Existing files are
+// overwritten, and entries of a different type are replaced.
+//
+// Symbolic links are recreated as is, and sockets, named pipes and
+// devices are skipped. Permissions, modification times and (when
+// running with enough privileges) ownership are preserved. Blocks of
+// zeros, such as the unused parts of InnoDB tablespaces, are written
+// as holes, so that sparse files stay sparse
+func Dircopy(from string, to string) error {
+	info, err := os.Stat(from)
+	if err != nil {
+		return errors.Wrapf(err, `failed to stat %s`, from)
+	}
+	if !info.IsDir() {
+		return errors.Errorf(`%s is not a directory`, from)
+	}
+	return copyDir(from, to, info)
+}
+
+func copyDir(src, dst string, info os.FileInfo) error {
+	if err := prepareDest(dst, os.ModeDir); err != nil {
+		return err
+	}
+
+	// Make sure we can write to the directory while copying; the
+	// original permissions are applied at the end
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return errors.Wrapf(err, `failed to create directory %s`, dst)
+	}
+	if err := os.Chmod(dst, 0700); err != nil {
+		return errors.Wrapf(err, `failed to change permissions of %s`, dst)
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return errors.Wrapf(err, `failed to read directory %s`, src)
+	}
+
+	for _, entry := range entries {
+		if err := copyEntry(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), entry); err != nil {
+			return err
+		}
+	}
+
+	return copyMetadata(dst, info)
+}
+
+func copyEntry(src, dst string, info os.FileInfo) error {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return copyDir(src, dst, info)
+	case mode&os.ModeSymlink != 0:
+		return copySymlink(src, dst, info)
+	case mode.IsRegular():
+		return copyFile(src, dst, info)
+	default:
+		// Sockets, named pipes and devices cannot be copied
+		return nil
+	}
+}
+
+// prepareDest removes dst if it exists but is not of the given type
+// (os.ModeDir, os.ModeSymlink, or 0 for a regular file)
+func prepareDest(dst string, typ os.FileMode) error {
+	info, err := os.Lstat(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, `failed to stat %s`, dst)
+	}
+
+	if info.Mode()&os.ModeType == typ && typ != os.ModeSymlink {
+		return nil
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return errors.Wrapf(err, `failed to remove %s`, dst)
+	}
+	return nil
+}
+
+func copySymlink(src, dst string, info os.FileInfo) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return errors.Wrapf(err, `failed to read symbolic link %s`, src)
+	}
+
+	if err := prepareDest(dst, os.ModeSymlink); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		return errors.Wrapf(err, `failed to create symbolic link %s`, dst)
+	}
+	return copyOwner(dst, info)
+}
+
+func copyFile(src, dst string, info os.FileInfo) error {
+	if err := prepareDest(dst, 0); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, `failed to open %s`, src)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, `failed to create %s`, dst)
+	}
+
+	if err := copySparse(out, in); err != nil {
+		out.Close()
+		return errors.Wrapf(err, `failed to copy %s to %s`, src, dst)
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrapf(err, `failed to close %s`, dst)
+	}
+
+	return copyMetadata(dst, info)
+}
+
+// copySparse copies the contents of in to out, seeking over blocks of
+// zeros instead of writing them
+func copySparse(out *os.File, in io.Reader) error {
+	buf := make([]byte, 32*sparseBlockSize)
+	for {
+		n, err := io.ReadFull(in, buf)
+		if n > 0 {
+			if werr := writeSparse(out, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Extend the file in case it ends with a hole
+	size, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return out.Truncate(size)
+}
+
+func writeSparse(out *os.File, p []byte) error {
+	for len(p) > 0 {
+		// Find the next run of blocks that are either all zeros,
+		// or all contain data
+		n := blockLen(p)
+		zero := isZero(p[:n])
+		for n < len(p) {
+			next := blockLen(p[n:])
+			if isZero(p[n:n+next]) != zero {
+				break
+			}
+			n += next
+		}
+
+		if zero {
+			if _, err := out.Seek(int64(n), io.SeekCurrent); err != nil {
+				return err
+			}
+		} else if _, err := out.Write(p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func blockLen(p []byte) int {
+	if len(p) < sparseBlockSize {
+		return len(p)
+	}
+	return sparseBlockSize
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// copyMetadata applies the permissions, ownership and modification
+// time in info to path
+func copyMetadata(path string, info os.FileInfo) error {
+	if err := copyOwner(path, info); err != nil {
+		return err
+	}
+
+	// chown may clear the setuid and setgid bits, so chmod afterwards
+	mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(path, mode); err != nil {
+		return errors.Wrapf(err, `failed to change permissions of %s`, path)
+	}
+
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		return errors.Wrapf(err, `failed to change times of %s`, path)
+	}
+	return nil
+}
+
+// copyOwner applies the ownership in info to path. Only root may give
+// files away, so permission errors are ignored
+func copyOwner(path string, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	if err := os.Lchown(path, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return errors.Wrapf(err, `failed to change owner of %s`, path)
+	}
+	return nil
+}
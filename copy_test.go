@@ -0,0 +1,132 @@
+package mysqltest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDircopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-copy")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	// src/
+	//   ibdata1       (sparse, with data at both ends)
+	//   my.cnf        (0600)
+	//   sub/t.frm
+	//   link -> sub/t.frm
+	//   fifo
+	mtime := time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC)
+	if !assert.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0750), "MkdirAll should succeed") {
+		return
+	}
+
+	sparse := make([]byte, 1024*1024)
+	copy(sparse, "head")
+	copy(sparse[len(sparse)-4:], "tail")
+	for name, content := range map[string][]byte{
+		"ibdata1":   sparse,
+		"my.cnf":    []byte("[mysqld]\n"),
+		"sub/t.frm": []byte("frm"),
+	} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if !assert.NoError(t, ioutil.WriteFile(path, content, 0644), "WriteFile should succeed") {
+			return
+		}
+		if !assert.NoError(t, os.Chtimes(path, mtime, mtime), "Chtimes should succeed") {
+			return
+		}
+	}
+	if !assert.NoError(t, os.Chmod(filepath.Join(src, "my.cnf"), 0600), "Chmod should succeed") {
+		return
+	}
+	if !assert.NoError(t, os.Symlink(filepath.Join("sub", "t.frm"), filepath.Join(src, "link")), "Symlink should succeed") {
+		return
+	}
+	if !assert.NoError(t, syscall.Mkfifo(filepath.Join(src, "fifo"), 0644), "Mkfifo should succeed") {
+		return
+	}
+
+	// Stale destination: a longer file, and a directory where a file goes
+	if !assert.NoError(t, os.MkdirAll(filepath.Join(dst, "my.cnf"), 0755), "MkdirAll should succeed") {
+		return
+	}
+	if !assert.NoError(t, os.MkdirAll(filepath.Join(dst, "sub"), 0755), "MkdirAll should succeed") {
+		return
+	}
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dst, "sub", "t.frm"), []byte("stale content"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, Dircopy(src, dst), "Dircopy should succeed") {
+		return
+	}
+
+	for name, expected := range map[string][]byte{
+		"ibdata1":   sparse,
+		"my.cnf":    []byte("[mysqld]\n"),
+		"sub/t.frm": []byte("frm"),
+		"link":      []byte("frm"),
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if !assert.NoError(t, err, "ReadFile should succeed for %s", name) {
+			return
+		}
+		if !assert.True(t, bytes.Equal(expected, content), "content of %s matches", name) {
+			return
+		}
+	}
+
+	fi, err := os.Stat(filepath.Join(dst, "my.cnf"))
+	if !assert.NoError(t, err, "Stat should succeed") {
+		return
+	}
+	if !assert.Equal(t, os.FileMode(0600), fi.Mode(), "mode is preserved") {
+		return
+	}
+	if !assert.True(t, mtime.Equal(fi.ModTime()), "mtime is preserved") {
+		return
+	}
+
+	fi, err = os.Stat(filepath.Join(dst, "sub"))
+	if !assert.NoError(t, err, "Stat should succeed") {
+		return
+	}
+	if !assert.Equal(t, os.ModeDir|0750, fi.Mode(), "directory mode is preserved") {
+		return
+	}
+
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	if !assert.NoError(t, err, "link should be a symbolic link") {
+		return
+	}
+	if !assert.Equal(t, filepath.Join("sub", "t.frm"), target, "link target is preserved") {
+		return
+	}
+
+	if _, err := os.Lstat(filepath.Join(dst, "fifo")); !assert.True(t, os.IsNotExist(err), "fifo is skipped") {
+		return
+	}
+
+	t.Run("Errors include the path", func(t *testing.T) {
+		err := Dircopy(filepath.Join(dir, "missing"), dst)
+		if !assert.Error(t, err, "Dircopy should fail") {
+			return
+		}
+		if !assert.Contains(t, err.Error(), filepath.Join(dir, "missing"), "error contains the path") {
+			return
+		}
+	})
+}
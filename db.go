@@ -0,0 +1,81 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pool limits applied to handles returned by DB. Tests rarely need more
+// than a few connections, and small limits make leaks show up early
+const (
+	dbMaxOpenConns    = 10
+	dbMaxIdleConns    = 2
+	dbConnMaxLifetime = 5 * time.Minute
+)
+
+// DB returns a *sql.DB connected to the database instance started by
+// TestMysqld. Options are handled in the same way as DSN, except that
+// parseTime is enabled unless specified otherwise.
+//
+// The handle is pinged before it is returned, and is shared between all
+// callers that pass the same set of options. It is closed when Stop is
+// called, so callers must not close it themselves
+func (m *TestMysqld) DB(options ...DatasourceOption) (*sql.DB, error) {
+	options = dbOptions(options)
+
+	key, err := m.DSNE(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dbMu.Lock()
+	defer m.dbMu.Unlock()
+
+	if db, ok := m.dbs[key]; ok {
+		return db, nil
+	}
+
+	connector, err := m.Connector(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(dbMaxOpenConns)
+	db.SetMaxIdleConns(dbMaxIdleConns)
+	db.SetConnMaxLifetime(dbConnMaxLifetime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+
+	if m.dbs == nil {
+		m.dbs = make(map[string]*sql.DB)
+	}
+	m.dbs[key] = db
+	return db, nil
+}
+
+// dbOptions enables parseTime unless specified otherwise
+func dbOptions(options []DatasourceOption) []DatasourceOption {
+	for _, o := range options {
+		if o.Name() == "parseTime" {
+			return options
+		}
+	}
+	return append([]DatasourceOption{WithParseTime(true)}, options...)
+}
+
+// closeDBs closes all handles returned by DB
+func (m *TestMysqld) closeDBs() {
+	m.dbMu.Lock()
+	defer m.dbMu.Unlock()
+
+	for _, db := range m.dbs {
+		db.Close()
+	}
+	m.dbs = nil
+}
@@ -0,0 +1,48 @@
+package mysqltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB(t *testing.T) {
+	mysqld, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+
+	again, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	if !assert.True(t, db == again, "handles are cached") {
+		return
+	}
+
+	other, err := mysqld.DB(WithDbname("mysql"))
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	if !assert.False(t, db == other, "handles differ per option set") {
+		return
+	}
+
+	// parseTime is enabled by default
+	var now time.Time
+	if err := db.QueryRow("SELECT NOW()").Scan(&now); !assert.NoError(t, err, "scanning into time.Time should succeed") {
+		return
+	}
+
+	mysqld.Stop()
+	if !assert.Error(t, db.Ping(), "handle is closed by Stop") {
+		return
+	}
+}
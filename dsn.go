@@ -0,0 +1,360 @@
+package mysqltest
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+type dsnParam struct {
+	key   string
+	value string
+}
+
+func invalidValue(o DatasourceOption, expected string) error {
+	return errors.Errorf(`invalid value for option %s: expected %s, got %T`, o.Name(), expected, o.Value())
+}
+
+func stringValue(o DatasourceOption) (string, error) {
+	v, ok := o.Value().(string)
+	if !ok {
+		return "", invalidValue(o, "string")
+	}
+	return v, nil
+}
+
+func boolValue(o DatasourceOption) (bool, error) {
+	v, ok := o.Value().(bool)
+	if !ok {
+		return false, invalidValue(o, "bool")
+	}
+	return v, nil
+}
+
+func intValue(o DatasourceOption) (int, error) {
+	v, ok := o.Value().(int)
+	if !ok {
+		return 0, invalidValue(o, "int")
+	}
+	return v, nil
+}
+
+func durationValue(o DatasourceOption) (time.Duration, error) {
+	v, ok := o.Value().(time.Duration)
+	if !ok {
+		return 0, invalidValue(o, "time.Duration")
+	}
+	return v, nil
+}
+
+func locationValue(o DatasourceOption) (*time.Location, error) {
+	v, ok := o.Value().(*time.Location)
+	if !ok || v == nil {
+		return nil, invalidValue(o, "*time.Location")
+	}
+	return v, nil
+}
+
+// DriverConfigE creates a mysql.Config from the given options, which can
+// be passed to mysql.NewConnector, or further customized before calling
+// FormatDSN. Unless overridden, the user is "root", the database is
+// "test", and the address is "tcp(localhost:3306)".
+//
+// An error is returned if an option has an unknown name, a value of the
+// wrong type, or if the protocol is not one of "unix", "tcp", "tcp4"
+// or "tcp6"
+func DriverConfigE(options ...DatasourceOption) (*mysql.Config, error) {
+	cfg, err := newDriverConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	cfg.User = "root"
+	cfg.DBName = "test"
+	cfg.Net = "tcp"
+
+	host := "localhost"
+	socket := ""
+	port := 3306
+
+	for _, o := range options {
+		var err error
+		name := o.Name()
+		switch name {
+		case "host":
+			host, err = stringValue(o)
+		case "dbname":
+			cfg.DBName, err = stringValue(o)
+		case "user":
+			cfg.User, err = stringValue(o)
+		case "password":
+			cfg.Passwd, err = stringValue(o)
+		case "proto":
+			cfg.Net, err = stringValue(o)
+		case "socket":
+			socket, err = stringValue(o)
+		case "port":
+			port, err = intValue(o)
+		case "parseTime":
+			cfg.ParseTime, err = boolValue(o)
+		case "multiStatements":
+			cfg.MultiStatements, err = boolValue(o)
+		case "tls":
+			cfg.TLSConfig, err = stringValue(o)
+		case "allowNativePasswords":
+			cfg.AllowNativePasswords, err = boolValue(o)
+		case "allowCleartextPasswords":
+			cfg.AllowCleartextPasswords, err = boolValue(o)
+		case "allowFallbackToPlaintext":
+			cfg.AllowFallbackToPlaintext, err = boolValue(o)
+		case "serverPubKey":
+			cfg.ServerPubKey, err = stringValue(o)
+		case "loc":
+			cfg.Loc, err = locationValue(o)
+		case "collation":
+			cfg.Collation, err = stringValue(o)
+		case "charset":
+			// Applied by newDriverConfig
+			_, err = stringValue(o)
+		case "timeout":
+			cfg.Timeout, err = durationValue(o)
+		case "readTimeout":
+			cfg.ReadTimeout, err = durationValue(o)
+		case "writeTimeout":
+			cfg.WriteTimeout, err = durationValue(o)
+		case "interpolateParams":
+			cfg.InterpolateParams, err = boolValue(o)
+		case "clientFoundRows":
+			cfg.ClientFoundRows, err = boolValue(o)
+		case "maxAllowedPacket":
+			cfg.MaxAllowedPacket, err = intValue(o)
+		case "connectionAttributes":
+			cfg.ConnectionAttributes, err = stringValue(o)
+		case "param":
+			p, ok := o.Value().(dsnParam)
+			if !ok {
+				err = invalidValue(o, "a value created by WithParam")
+				break
+			}
+			if p.key != "charset" {
+				setParam(cfg, p.key, p.value)
+			}
+		default:
+			err = errors.Errorf(`unknown option %s`, name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Net {
+	case "unix":
+		cfg.Addr = socket
+	case "tcp", "tcp4", "tcp6":
+		cfg.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	default:
+		return nil, errors.Errorf(`invalid protocol %s: expected "unix", "tcp", "tcp4" or "tcp6"`, cfg.Net)
+	}
+
+	return cfg, nil
+}
+
+// newDriverConfig returns the driver's default configuration, with the
+// charset found in options, if any. Since go-sql-driver/mysql 1.9, the
+// charset is kept in an unexported field rather than in Params (which
+// are sent as SET statements), so it can only be set through the
+// driver's DSN parser
+func newDriverConfig(options []DatasourceOption) (*mysql.Config, error) {
+	var charset string
+	for _, o := range options {
+		switch o.Name() {
+		case "charset":
+			if v, ok := o.Value().(string); ok {
+				charset = v
+			}
+		case "param":
+			if p, ok := o.Value().(dsnParam); ok && p.key == "charset" {
+				charset = p.value
+			}
+		}
+	}
+
+	if charset == "" {
+		return mysql.NewConfig(), nil
+	}
+	cfg, err := mysql.ParseDSN("/?charset=" + url.QueryEscape(charset))
+	if err != nil {
+		return nil, errors.Wrapf(err, `invalid charset %s`, charset)
+	}
+	return cfg, nil
+}
+
+// driverCharset returns the charset of cfg, in the same way as
+// newDriverConfig sets it
+func driverCharset(cfg *mysql.Config) string {
+	c := cfg.Clone()
+	c.User, c.Passwd, c.DBName = "", "", ""
+	dsn := c.FormatDSN()
+	i := strings.IndexByte(dsn, '?')
+	if i < 0 {
+		return ""
+	}
+	values, err := url.ParseQuery(dsn[i+1:])
+	if err != nil {
+		return ""
+	}
+	return values.Get("charset")
+}
+
+func setParam(cfg *mysql.Config, key, value string) {
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	cfg.Params[key] = value
+}
+
+// DriverConfig is like DriverConfigE, but invalid options are ignored,
+// and invalid protocols are treated as "tcp"
+func DriverConfig(options ...DatasourceOption) *mysql.Config {
+	list := make([]DatasourceOption, 0, len(options))
+	for _, o := range options {
+		if _, err := DriverConfigE(o); err != nil {
+			if o.Name() != "proto" {
+				continue
+			}
+			o = WithProto("tcp")
+		}
+		list = append(list, o)
+	}
+
+	cfg, _ := DriverConfigE(list...)
+	return cfg
+}
+
+// Datasource is a utility function to format the DSN that can be passed
+// to mysqld driver. Invalid options are ignored. Use DatasourceE to
+// detect them
+func Datasource(options ...DatasourceOption) string {
+	return formatDSN(DriverConfig(options...))
+}
+
+// DatasourceE is like Datasource, but returns an error if any of the
+// options are invalid
+func DatasourceE(options ...DatasourceOption) (string, error) {
+	cfg, err := DriverConfigE(options...)
+	if err != nil {
+		return "", err
+	}
+	return formatDSN(cfg), nil
+}
+
+// formatDSN formats cfg like mysql.Config.FormatDSN, except that the
+// colon after the user name is kept even if the password is empty,
+// which is the format this package has always produced
+func formatDSN(cfg *mysql.Config) string {
+	s := cfg.FormatDSN()
+	if cfg.User != "" && cfg.Passwd == "" {
+		s = cfg.User + ":" + s[len(cfg.User):]
+	}
+	return s
+}
+
+// ParseDSN parses a DSN string, and returns the list of options that
+// Datasource needs to produce an equivalent DSN
+func ParseDSN(dsn string) ([]DatasourceOption, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to parse DSN`)
+	}
+
+	options := []DatasourceOption{
+		WithProto(cfg.Net),
+		WithUser(cfg.User),
+		WithDbname(cfg.DBName),
+	}
+
+	if cfg.Passwd != "" {
+		options = append(options, WithPassword(cfg.Passwd))
+	}
+
+	if cfg.Net == "unix" {
+		options = append(options, WithSocket(cfg.Addr))
+	} else {
+		host, portStr, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to parse address %s`, cfg.Addr)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to parse port in %s`, cfg.Addr)
+		}
+		options = append(options, WithHost(host), WithPort(port))
+	}
+
+	// Only include values that differ from the driver defaults
+	defaults := mysql.NewConfig()
+	if cfg.ParseTime != defaults.ParseTime {
+		options = append(options, WithParseTime(cfg.ParseTime))
+	}
+	if cfg.MultiStatements != defaults.MultiStatements {
+		options = append(options, WithMultiStatements(cfg.MultiStatements))
+	}
+	if cfg.TLSConfig != defaults.TLSConfig {
+		options = append(options, WithTLS(cfg.TLSConfig))
+	}
+	if cfg.AllowNativePasswords != defaults.AllowNativePasswords {
+		options = append(options, WithAllowNativePasswords(cfg.AllowNativePasswords))
+	}
+	if cfg.AllowCleartextPasswords != defaults.AllowCleartextPasswords {
+		options = append(options, WithAllowCleartextPasswords(cfg.AllowCleartextPasswords))
+	}
+	if cfg.AllowFallbackToPlaintext != defaults.AllowFallbackToPlaintext {
+		options = append(options, WithAllowFallbackToPlaintext(cfg.AllowFallbackToPlaintext))
+	}
+	if cfg.ServerPubKey != defaults.ServerPubKey {
+		options = append(options, WithServerPubKey(cfg.ServerPubKey))
+	}
+	if cfg.Loc != defaults.Loc {
+		options = append(options, WithLoc(cfg.Loc))
+	}
+	if cfg.Collation != defaults.Collation {
+		options = append(options, WithCollation(cfg.Collation))
+	}
+	if cfg.Timeout != defaults.Timeout {
+		options = append(options, WithTimeout(cfg.Timeout))
+	}
+	if cfg.ReadTimeout != defaults.ReadTimeout {
+		options = append(options, WithReadTimeout(cfg.ReadTimeout))
+	}
+	if cfg.WriteTimeout != defaults.WriteTimeout {
+		options = append(options, WithWriteTimeout(cfg.WriteTimeout))
+	}
+	if cfg.InterpolateParams != defaults.InterpolateParams {
+		options = append(options, WithInterpolateParams(cfg.InterpolateParams))
+	}
+	if cfg.ClientFoundRows != defaults.ClientFoundRows {
+		options = append(options, WithClientFoundRows(cfg.ClientFoundRows))
+	}
+	if cfg.MaxAllowedPacket != defaults.MaxAllowedPacket {
+		options = append(options, WithMaxAllowedPacket(cfg.MaxAllowedPacket))
+	}
+	if cfg.ConnectionAttributes != defaults.ConnectionAttributes {
+		options = append(options, WithConnectionAttributes(cfg.ConnectionAttributes))
+	}
+	if charset := driverCharset(cfg); charset != "" {
+		options = append(options, WithCharset(charset))
+	}
+	for key, value := range cfg.Params {
+		// go-sql-driver/mysql 1.8 keeps the charset in Params
+		if key != "charset" {
+			options = append(options, WithParam(key, value))
+		}
+	}
+
+	return options, nil
+}
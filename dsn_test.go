@@ -0,0 +1,95 @@
+package mysqltest
+
+import (
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+type badOption struct{}
+
+func (badOption) Name() string       { return "port" }
+func (badOption) Value() interface{} { return "3306" }
+
+func TestDatasourceE(t *testing.T) {
+	t.Run("Invalid value", func(t *testing.T) {
+		_, err := DatasourceE(badOption{})
+		if !assert.Error(t, err, "DatasourceE should fail") {
+			return
+		}
+		if !assert.Contains(t, err.Error(), "expected int, got string", "error message matches") {
+			return
+		}
+
+		// Datasource ignores the invalid option instead of panicking
+		if !assert.Equal(t, "root:@tcp(localhost:3306)/test", Datasource(badOption{}), "dsn matches") {
+			return
+		}
+	})
+	t.Run("Invalid protocol", func(t *testing.T) {
+		_, err := DatasourceE(WithProto("udp"))
+		if !assert.Error(t, err, "DatasourceE should fail") {
+			return
+		}
+		if !assert.Equal(t, "root:@tcp(localhost:3306)/test", Datasource(WithProto("udp")), "dsn matches") {
+			return
+		}
+	})
+	t.Run("Params", func(t *testing.T) {
+		dsn, err := DatasourceE(WithParam("sql_mode", "'TRADITIONAL'"), WithParam("autocommit", "1"))
+		if !assert.NoError(t, err, "DatasourceE should succeed") {
+			return
+		}
+		if !assert.Equal(t, "root:@tcp(localhost:3306)/test?autocommit=1&sql_mode=%27TRADITIONAL%27", dsn, "dsn matches") {
+			return
+		}
+	})
+}
+
+func TestParseDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"root:@unix(/tmp/mysql.sock)/test",
+		"app:s3cr3t@tcp(127.0.0.1:13306)/app?parseTime=true",
+		"root:@tcp6([::1]:3306)/test?clientFoundRows=true&readTimeout=1s&charset=utf8mb4&time_zone=%27%2B00%3A00%27",
+	} {
+		options, err := ParseDSN(dsn)
+		if !assert.NoError(t, err, "ParseDSN should succeed for %s", dsn) {
+			return
+		}
+
+		roundtrip, err := DatasourceE(options...)
+		if !assert.NoError(t, err, "DatasourceE should succeed for %s", dsn) {
+			return
+		}
+
+		// The order of the parameters depends on the driver version,
+		// so compare what the driver makes of them
+		expected, err := mysql.ParseDSN(dsn)
+		if !assert.NoError(t, err, "mysql.ParseDSN should succeed for %s", dsn) {
+			return
+		}
+		actual, err := mysql.ParseDSN(roundtrip)
+		if !assert.NoError(t, err, "mysql.ParseDSN should succeed for %s", roundtrip) {
+			return
+		}
+		if !assert.Equal(t, expected, actual, "DSN should round-trip") {
+			return
+		}
+	}
+}
+
+func TestCharset(t *testing.T) {
+	for _, option := range []DatasourceOption{WithCharset("utf8mb4"), WithParam("charset", "utf8mb4")} {
+		cfg, err := DriverConfigE(option)
+		if !assert.NoError(t, err, "DriverConfigE should succeed") {
+			return
+		}
+		if !assert.Equal(t, "utf8mb4", driverCharset(cfg), "charset is set in the driver") {
+			return
+		}
+		if !assert.Contains(t, cfg.FormatDSN(), "charset=utf8mb4", "charset is in the DSN") {
+			return
+		}
+	}
+}
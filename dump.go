@@ -0,0 +1,153 @@
+package mysqltest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DumpOptions controls the output of Dump
+type DumpOptions struct {
+	// Databases is the list of databases to dump. Defaults to "test"
+	Databases []string
+
+	// Tables limits the dump to the given tables. Only allowed when
+	// there is a single database
+	Tables []string
+
+	// NoData omits the table contents (schema only)
+	NoData bool
+
+	// NoCreateInfo omits the CREATE statements (data only)
+	NoCreateInfo bool
+
+	// Args is a list of extra arguments passed to mysqldump as is,
+	// such as "--skip-extended-insert"
+	Args []string
+
+	// Options is a list of options used to connect, as in DSN
+	Options []DatasourceOption
+}
+
+// lookClientPath finds a client program such as mysql or mysqldump,
+// looking in the same installation as config.Mysqld first
+func (m *TestMysqld) lookClientPath(name string) (string, error) {
+	dir := filepath.Dir(m.Config.Mysqld)
+	if fullpath, err := lookExecutablePath(name, dir, []string{"."}); err == nil {
+		return fullpath, nil
+	}
+	if fullpath, err := lookExecutablePath(name, filepath.Dir(dir), MysqldSearchDirs); err == nil {
+		return fullpath, nil
+	}
+
+	fullpath, err := exec.LookPath(name)
+	if err != nil {
+		return "", errors.Wrapf(err, `could not find %s`, name)
+	}
+	return fullpath, nil
+}
+
+// clientDefaultsFile writes a temporary option file for the client
+// programs. The returned function removes it
+func (m *TestMysqld) clientDefaultsFile(options ...DatasourceOption) (string, func(), error) {
+	file, err := ioutil.TempFile(m.Config.TmpDir, "client")
+	if err != nil {
+		return "", nil, errors.Wrap(err, `failed to create client option file`)
+	}
+	remove := func() { os.Remove(file.Name()) }
+
+	err = m.WriteClientConfig(file, options...)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		remove()
+		return "", nil, errors.Wrap(err, `failed to write client option file`)
+	}
+	return file.Name(), remove, nil
+}
+
+// runClient runs a client program with the option file for options,
+// and includes its error output in the returned error
+func (m *TestMysqld) runClient(name string, args []string, stdin io.Reader, stdout io.Writer, options ...DatasourceOption) error {
+	path, err := m.lookClientPath(name)
+	if err != nil {
+		return err
+	}
+
+	defaults, remove, err := m.clientDefaultsFile(options...)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, append([]string{"--defaults-file=" + defaults}, args...)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, `failed to execute %s: %s`, name, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Dump writes a logical dump of the database to w using mysqldump.
+// The dump date is omitted and rows are sorted by primary key, so that
+// dumps of the same state are identical. opts may be nil
+func (m *TestMysqld) Dump(w io.Writer, opts *DumpOptions) error {
+	if opts == nil {
+		opts = &DumpOptions{}
+	}
+
+	databases := opts.Databases
+	if len(databases) == 0 {
+		databases = []string{"test"}
+	}
+
+	args := []string{
+		"--skip-dump-date",
+		"--order-by-primary",
+		"--single-transaction",
+	}
+	if opts.NoData {
+		args = append(args, "--no-data")
+	}
+	if opts.NoCreateInfo {
+		args = append(args, "--no-create-info")
+	}
+	args = append(args, opts.Args...)
+
+	if len(opts.Tables) > 0 {
+		if len(databases) != 1 {
+			return errors.New(`tables can only be specified with a single database`)
+		}
+		args = append(args, databases[0])
+		args = append(args, opts.Tables...)
+	} else {
+		args = append(args, "--databases")
+		args = append(args, databases...)
+	}
+
+	return m.runClient("mysqldump", args, nil, w, opts.Options...)
+}
+
+// Import executes the SQL statements read from r, such as the output
+// of Dump, using the mysql client. Statements that do not select a
+// database run against the database given by options ("test" by default)
+func (m *TestMysqld) Import(r io.Reader, options ...DatasourceOption) error {
+	dbname := "test"
+	for _, o := range options {
+		if o.Name() == "dbname" {
+			dbname, _ = o.Value().(string)
+		}
+	}
+
+	return m.runClient("mysql", []string{"--batch", dbname}, r, ioutil.Discard, options...)
+}
@@ -0,0 +1,102 @@
+package mysqltest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookClientPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-bin")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"sbin/mysqld", "bin/mysqldump"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755), "MkdirAll should succeed") {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	m := &TestMysqld{
+		Config: &MysqldConfig{
+			Mysqld: filepath.Join(dir, "sbin", "mysqld"),
+		},
+	}
+
+	path, err := m.lookClientPath("mysqldump")
+	if !assert.NoError(t, err, "lookClientPath should succeed") {
+		return
+	}
+	if !assert.Equal(t, filepath.Join(dir, "bin", "mysqldump"), path, "mysqldump is found next to mysqld") {
+		return
+	}
+}
+
+func TestDumpImport(t *testing.T) {
+	src, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer src.Stop()
+
+	db, err := src.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE greetings (id INT NOT NULL PRIMARY KEY, message VARCHAR(64))",
+		"INSERT INTO greetings VALUES (2, 'world'), (1, 'hello')",
+	} {
+		if _, err := db.Exec(stmt); !assert.NoError(t, err, "Exec should succeed") {
+			return
+		}
+	}
+
+	var dump bytes.Buffer
+	if !assert.NoError(t, src.Dump(&dump, nil), "Dump should succeed") {
+		return
+	}
+	if !assert.Contains(t, dump.String(), "CREATE TABLE `greetings`", "dump contains the schema") {
+		return
+	}
+
+	var again bytes.Buffer
+	if !assert.NoError(t, src.Dump(&again, nil), "Dump should succeed") {
+		return
+	}
+	if !assert.Equal(t, dump.String(), again.String(), "dumps are reproducible") {
+		return
+	}
+
+	dst, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer dst.Stop()
+
+	if !assert.NoError(t, dst.Import(&dump), "Import should succeed") {
+		return
+	}
+
+	dstdb, err := dst.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	var message string
+	if err := dstdb.QueryRow("SELECT message FROM greetings WHERE id = 1").Scan(&message); !assert.NoError(t, err, "imported row should exist") {
+		return
+	}
+	if !assert.Equal(t, "hello", message, "message matches") {
+		return
+	}
+}
@@ -0,0 +1,671 @@
+package mysqltest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// FixtureFormat is the file format used by ExportTablesAs
+type FixtureFormat string
+
+// Supported fixture formats. The format is also used as the file
+// extension, as in "orders.json"
+const (
+	FixtureJSON FixtureFormat = "json"
+	FixtureCSV  FixtureFormat = "csv"
+	FixtureYAML FixtureFormat = "yaml"
+)
+
+// csvNull represents NULL in CSV fixtures by default, as in LOAD DATA
+// INFILE. See MysqldConfig.FixtureCSVNull
+const csvNull = `\N`
+
+// Queryer is implemented by *sql.DB and *sql.Tx
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// fixture holds the contents of a single table. Values are kept as
+// text, and nil represents NULL
+type fixture struct {
+	Columns []string
+	Rows    [][]*string
+}
+
+func (m *TestMysqld) fixtureDB() (*sql.DB, error) {
+	// Keep DATETIME and friends as text, so they can be reloaded as is
+	return m.DB(WithParseTime(false))
+}
+
+// ExportTables writes the contents of the given tables in the "test"
+// database to dir as JSON files, one per table. See ExportTablesAs
+func (m *TestMysqld) ExportTables(dir string, tables ...string) error {
+	return m.ExportTablesAs(dir, FixtureJSON, tables...)
+}
+
+// ExportTablesAs writes the contents of the given tables in the "test"
+// database to dir, one file per table named after it. If no tables
+// are given, all tables are exported. Rows are sorted by primary key
+// (or by all columns when there is none) and values are written as
+// text, so that the output is stable across runs and server versions.
+// Generated columns are skipped
+func (m *TestMysqld) ExportTablesAs(dir string, format FixtureFormat, tables ...string) error {
+	switch format {
+	case FixtureJSON, FixtureCSV, FixtureYAML:
+	default:
+		return errors.Errorf(`unsupported fixture format '%s'`, format)
+	}
+
+	db, err := m.fixtureDB()
+	if err != nil {
+		return err
+	}
+
+	if len(tables) == 0 {
+		tables, err = listTables(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, `failed to create fixture directory`)
+	}
+
+	for _, table := range tables {
+		f, err := readFixture(db, table)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		switch format {
+		case FixtureJSON:
+			err = writeFixtureJSON(&buf, f)
+		case FixtureCSV:
+			err = writeFixtureCSV(&buf, f, m.csvNull())
+		case FixtureYAML:
+			err = writeFixtureYAML(&buf, f)
+		}
+		if err != nil {
+			return errors.Wrapf(err, `failed to encode table %s`, table)
+		}
+
+		filename := filepath.Join(dir, table+"."+string(format))
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, `failed to write %s`, filename)
+		}
+	}
+	return nil
+}
+
+// ImportFixtures reloads the tables in the "test" database from the
+// files in dir that were written by ExportTables. Each table with a
+// fixture file is truncated first. Foreign key checks are disabled
+// while loading, so files can be loaded in any order
+func (m *TestMysqld) ImportFixtures(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, `failed to read fixture directory`)
+	}
+
+	fixtures := make(map[string]*fixture)
+	var tables []string
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(fi.Name())
+		table := strings.TrimSuffix(fi.Name(), ext)
+
+		var read func(io.Reader) (*fixture, error)
+		switch ext {
+		case ".json":
+			read = readFixtureJSON
+		case ".csv":
+			read = func(r io.Reader) (*fixture, error) {
+				return readFixtureCSV(r, m.csvNull())
+			}
+		case ".yaml", ".yml":
+			read = readFixtureYAML
+		default:
+			continue
+		}
+
+		if _, ok := fixtures[table]; ok {
+			return errors.Errorf(`multiple fixture files for table %s`, table)
+		}
+
+		filename := filepath.Join(dir, fi.Name())
+		file, err := os.Open(filename)
+		if err != nil {
+			return errors.Wrapf(err, `failed to open %s`, filename)
+		}
+		f, err := read(file)
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, `failed to parse %s`, filename)
+		}
+
+		fixtures[table] = f
+		tables = append(tables, table)
+	}
+
+	db, err := m.fixtureDB()
+	if err != nil {
+		return err
+	}
+
+	// FOREIGN_KEY_CHECKS is per session, so everything has to
+	// happen on the same connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, `failed to connect to database`)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return errors.Wrap(err, `failed to disable foreign key checks`)
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
+	for _, table := range tables {
+		if err := loadFixture(ctx, conn, table, fixtures[table]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listTables(db Queryer) ([]string, error) {
+	rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name")
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to list tables`)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, errors.Wrap(err, `failed to list tables`)
+		}
+		tables = append(tables, table)
+	}
+	return tables, errors.Wrap(rows.Err(), `failed to list tables`)
+}
+
+// quoteIdentifier quotes s as a table or column name
+func quoteIdentifier(s string) string {
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+func queryStrings(db Queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func readFixture(db Queryer, table string) (*fixture, error) {
+	columns, err := queryStrings(db, "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND extra NOT IN ('VIRTUAL GENERATED', 'STORED GENERATED') ORDER BY ordinal_position", table)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read columns of %s`, table)
+	}
+	if len(columns) == 0 {
+		return nil, errors.Errorf(`table %s does not exist`, table)
+	}
+
+	order, err := queryStrings(db, "SELECT column_name FROM information_schema.key_column_usage WHERE table_schema = DATABASE() AND table_name = ? AND constraint_name = 'PRIMARY' ORDER BY ordinal_position", table)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read primary key of %s`, table)
+	}
+	if len(order) == 0 {
+		order = columns
+	}
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteIdentifier(c)
+	}
+	orderBy := make([]string, len(order))
+	for i, c := range order {
+		orderBy[i] = quoteIdentifier(c)
+	}
+
+	rows, err := db.Query("SELECT " + strings.Join(quoted, ", ") + " FROM " + quoteIdentifier(table) + " ORDER BY " + strings.Join(orderBy, ", "))
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read table %s`, table)
+	}
+	defer rows.Close()
+
+	f, err := scanFixture(rows)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read table %s`, table)
+	}
+	return f, nil
+}
+
+// scanFixture reads all rows as text
+func scanFixture(rows *sql.Rows) (*fixture, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fixture{Columns: columns}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]*string, len(columns))
+		for i, v := range values {
+			if !v.Valid {
+				continue
+			}
+			if !utf8.ValidString(v.String) {
+				return nil, errors.Errorf(`column %s contains binary data`, columns[i])
+			}
+			s := v.String
+			row[i] = &s
+		}
+		f.Rows = append(f.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func loadFixture(ctx context.Context, conn *sql.Conn, table string, f *fixture) error {
+	if _, err := conn.ExecContext(ctx, "TRUNCATE TABLE "+quoteIdentifier(table)); err != nil {
+		return errors.Wrapf(err, `failed to truncate table %s`, table)
+	}
+	if len(f.Rows) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(f.Columns))
+	placeholders := make([]string, len(f.Columns))
+	for i, c := range f.Columns {
+		quoted[i] = quoteIdentifier(c)
+		placeholders[i] = "?"
+	}
+
+	stmt, err := conn.PrepareContext(ctx, "INSERT INTO "+quoteIdentifier(table)+" ("+strings.Join(quoted, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")")
+	if err != nil {
+		return errors.Wrapf(err, `failed to prepare insert into %s`, table)
+	}
+	defer stmt.Close()
+
+	args := make([]interface{}, len(f.Columns))
+	for n, row := range f.Rows {
+		for i, v := range row {
+			if v == nil {
+				args[i] = nil
+			} else {
+				args[i] = *v
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return errors.Wrapf(err, `failed to insert row %d into %s`, n+1, table)
+		}
+	}
+	return nil
+}
+
+// jsonString encodes s as a JSON string, which is also a valid
+// double-quoted YAML scalar
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func jsonValue(v *string) string {
+	if v == nil {
+		return "null"
+	}
+	return jsonString(*v)
+}
+
+// writeFixtureJSON writes an array of objects, one per row, keeping
+// the keys in column order
+func writeFixtureJSON(w io.Writer, f *fixture) error {
+	bw := bufio.NewWriter(w)
+	if len(f.Rows) == 0 {
+		bw.WriteString("[]\n")
+		return bw.Flush()
+	}
+
+	bw.WriteString("[\n")
+	for n, row := range f.Rows {
+		bw.WriteString("  {")
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteString(", ")
+			}
+			bw.WriteString(jsonString(f.Columns[i]))
+			bw.WriteString(": ")
+			bw.WriteString(jsonValue(v))
+		}
+		bw.WriteString("}")
+		if n < len(f.Rows)-1 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n")
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+func readFixtureJSON(r io.Reader) (*fixture, error) {
+	var rows []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, err
+	}
+
+	f := &fixture{}
+	for _, raw := range rows {
+		// Decode twice: once for the values, and once to find the key order
+		var values map[string]*string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, err
+		}
+
+		keys, err := jsonObjectKeys(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.addRow(keys, values); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func jsonObjectKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil { // '{'
+		return nil, err
+	}
+
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// addRow appends a row given as a map. The first row determines the
+// columns, and all following rows must have the same ones
+func (f *fixture) addRow(keys []string, values map[string]*string) error {
+	if f.Columns == nil {
+		f.Columns = keys
+	} else if len(keys) != len(f.Columns) {
+		return errors.Errorf(`row %d has %d columns, expected %d`, len(f.Rows)+1, len(keys), len(f.Columns))
+	}
+
+	row := make([]*string, len(f.Columns))
+	for i, c := range f.Columns {
+		v, ok := values[c]
+		if !ok {
+			return errors.Errorf(`row %d is missing column %s`, len(f.Rows)+1, c)
+		}
+		row[i] = v
+	}
+	f.Rows = append(f.Rows, row)
+	return nil
+}
+
+// csvNull returns the marker for NULL in CSV fixtures
+func (m *TestMysqld) csvNull() string {
+	if m.Config != nil && m.Config.FixtureCSVNull != "" {
+		return m.Config.FixtureCSVNull
+	}
+	return csvNull
+}
+
+// writeFixtureCSV writes a header line with the column names followed
+// by one line per row. NULL is written as null. A value equal to null
+// would be read back as NULL, so it is reported as an error instead
+func writeFixtureCSV(w io.Writer, f *fixture, null string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(f.Columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(f.Columns))
+	for _, row := range f.Rows {
+		for i, v := range row {
+			switch {
+			case v == nil:
+				record[i] = null
+			case *v == null:
+				return errors.Errorf(`value of column %s is the same as the NULL marker %s: choose a different config.FixtureCSVNull`, f.Columns[i], null)
+			default:
+				record[i] = *v
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readFixtureCSV(r io.Reader, null string) (*fixture, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New(`missing header line`)
+	}
+
+	f := &fixture{Columns: records[0]}
+	for _, record := range records[1:] {
+		row := make([]*string, len(record))
+		for i := range record {
+			if record[i] != null {
+				row[i] = &record[i]
+			}
+		}
+		f.Rows = append(f.Rows, row)
+	}
+	return f, nil
+}
+
+var yamlPlainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func yamlKey(s string) string {
+	if yamlPlainKey.MatchString(s) && s != "null" {
+		return s
+	}
+	return jsonString(s)
+}
+
+// writeFixtureYAML writes a sequence of mappings, one per row. Values
+// are always double-quoted, so that they keep their type as strings
+func writeFixtureYAML(w io.Writer, f *fixture) error {
+	bw := bufio.NewWriter(w)
+	if len(f.Rows) == 0 {
+		bw.WriteString("[]\n")
+		return bw.Flush()
+	}
+
+	for _, row := range f.Rows {
+		for i, v := range row {
+			if i == 0 {
+				bw.WriteString("- ")
+			} else {
+				bw.WriteString("  ")
+			}
+			bw.WriteString(yamlKey(f.Columns[i]))
+			bw.WriteString(": ")
+			bw.WriteString(jsonValue(v))
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// readFixtureYAML reads the subset of YAML written by writeFixtureYAML:
+// a sequence of flat mappings whose values are double-quoted strings,
+// plain scalars, or null
+func readFixtureYAML(r io.Reader) (*fixture, error) {
+	f := &fixture{}
+
+	var keys []string
+	var values map[string]*string
+	flush := func() error {
+		if values == nil {
+			return nil
+		}
+		err := f.addRow(keys, values)
+		keys, values = nil, nil
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "[]" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "- "):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			values = make(map[string]*string)
+			line = line[2:]
+		case strings.HasPrefix(line, "  ") && values != nil:
+			line = line[2:]
+		default:
+			return nil, errors.Errorf(`line %d: unexpected content`, lineno)
+		}
+
+		key, value, err := parseYAMLPair(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, `line %d`, lineno)
+		}
+		if _, ok := values[key]; ok {
+			return nil, errors.Errorf(`line %d: duplicate key %s`, lineno, key)
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseYAMLPair(line string) (string, *string, error) {
+	var key string
+	if strings.HasPrefix(line, `"`) {
+		end := closingQuote(line)
+		if end < 0 {
+			return "", nil, errors.New(`unterminated key`)
+		}
+		if err := json.Unmarshal([]byte(line[:end+1]), &key); err != nil {
+			return "", nil, errors.Wrap(err, `invalid key`)
+		}
+		line = line[end+1:]
+	} else {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return "", nil, errors.New(`missing ':'`)
+		}
+		key = strings.TrimSpace(line[:i])
+		line = line[i:]
+	}
+
+	if !strings.HasPrefix(line, ":") {
+		return "", nil, errors.New(`missing ':'`)
+	}
+	raw := strings.TrimSpace(line[1:])
+
+	switch {
+	case raw == "null" || raw == "~" || raw == "":
+		return key, nil, nil
+	case strings.HasPrefix(raw, `"`):
+		var s string
+		if err := json.Unmarshal([]byte(raw), &s); err != nil {
+			return "", nil, errors.Wrap(err, `invalid value`)
+		}
+		return key, &s, nil
+	case strings.HasPrefix(raw, `'`) && strings.HasSuffix(raw, `'`) && len(raw) >= 2:
+		s := strings.Replace(raw[1:len(raw)-1], `''`, `'`, -1)
+		return key, &s, nil
+	default:
+		return key, &raw, nil
+	}
+}
+
+// closingQuote returns the index of the double quote that terminates
+// the string starting at s[0], or -1
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,215 @@
+package mysqltest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixtureFormats(t *testing.T) {
+	str := func(s string) *string { return &s }
+	f := &fixture{
+		Columns: []string{"id", "name", "note"},
+		Rows: [][]*string{
+			{str("1"), str(`say "hi"`), nil},
+			{str("2"), str("line\nbreak, comma"), str("null")},
+		},
+	}
+
+	for _, tc := range []struct {
+		format   FixtureFormat
+		write    func(io.Writer, *fixture) error
+		read     func(io.Reader) (*fixture, error)
+		expected string
+	}{
+		{
+			format: FixtureJSON,
+			write:  writeFixtureJSON,
+			read:   readFixtureJSON,
+			expected: "[\n" +
+				`  {"id": "1", "name": "say \"hi\"", "note": null},` + "\n" +
+				`  {"id": "2", "name": "line\nbreak, comma", "note": "null"}` + "\n" +
+				"]\n",
+		},
+		{
+			format: FixtureCSV,
+			write: func(w io.Writer, f *fixture) error {
+				return writeFixtureCSV(w, f, csvNull)
+			},
+			read: func(r io.Reader) (*fixture, error) {
+				return readFixtureCSV(r, csvNull)
+			},
+			expected: "id,name,note\n" +
+				`1,"say ""hi""",\N` + "\n" +
+				"2,\"line\nbreak, comma\",null\n",
+		},
+		{
+			format: FixtureYAML,
+			write:  writeFixtureYAML,
+			read:   readFixtureYAML,
+			expected: `- id: "1"` + "\n" +
+				`  name: "say \"hi\""` + "\n" +
+				`  note: null` + "\n" +
+				`- id: "2"` + "\n" +
+				`  name: "line\nbreak, comma"` + "\n" +
+				`  note: "null"` + "\n",
+		},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if !assert.NoError(t, tc.write(&buf, f), "write should succeed") {
+				return
+			}
+			if !assert.Equal(t, tc.expected, buf.String(), "output matches") {
+				return
+			}
+
+			got, err := tc.read(&buf)
+			if !assert.NoError(t, err, "read should succeed") {
+				return
+			}
+			if !assert.Equal(t, f, got, "round trip matches") {
+				return
+			}
+		})
+	}
+}
+
+func TestFixtureCSVNull(t *testing.T) {
+	str := func(s string) *string { return &s }
+	f := &fixture{
+		Columns: []string{"id", "path"},
+		Rows:    [][]*string{{str("1"), str(`C:\N`)}, {str("2"), str(`\N`)}, {str("3"), nil}},
+	}
+
+	var buf bytes.Buffer
+	if !assert.Error(t, writeFixtureCSV(&buf, f, csvNull), "a literal \\N cannot be written") {
+		return
+	}
+
+	buf.Reset()
+	if !assert.NoError(t, writeFixtureCSV(&buf, f, "NULL"), "write should succeed") {
+		return
+	}
+	if !assert.Equal(t, "id,path\n1,C:\\N\n2,\\N\n3,NULL\n", buf.String(), "output matches") {
+		return
+	}
+
+	got, err := readFixtureCSV(&buf, "NULL")
+	if !assert.NoError(t, err, "read should succeed") {
+		return
+	}
+	if !assert.Equal(t, f, got, "round trip matches") {
+		return
+	}
+
+	m := &TestMysqld{Config: &MysqldConfig{}}
+	if !assert.Equal(t, csvNull, m.csvNull(), "defaults to \\N") {
+		return
+	}
+}
+
+func TestReadFixtureYAML(t *testing.T) {
+	// Hand-written files may use plain scalars and quoted keys
+	f, err := readFixtureYAML(bytes.NewBufferString("---\n# orders\n- id: 1\n  \"total: usd\": '9.99'\n  note: ~\n"))
+	if !assert.NoError(t, err, "readFixtureYAML should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"id", "total: usd", "note"}, f.Columns, "columns match") {
+		return
+	}
+	if !assert.Equal(t, "9.99", *f.Rows[0][1], "value matches") {
+		return
+	}
+	if !assert.Nil(t, f.Rows[0][2], "note is NULL") {
+		return
+	}
+}
+
+func TestExportImportFixtures(t *testing.T) {
+	mysqld, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE customers (id INT NOT NULL PRIMARY KEY, name VARCHAR(64) NOT NULL)",
+		"CREATE TABLE orders (id INT NOT NULL PRIMARY KEY, customer_id INT NOT NULL, created_at DATETIME, FOREIGN KEY (customer_id) REFERENCES customers (id))",
+		"INSERT INTO customers VALUES (2, 'bob'), (1, 'alice')",
+		"INSERT INTO orders VALUES (10, 1, '2018-12-01 00:00:00'), (11, 2, NULL)",
+	} {
+		if _, err := db.Exec(stmt); !assert.NoError(t, err, "Exec should succeed") {
+			return
+		}
+	}
+
+	for _, format := range []FixtureFormat{FixtureJSON, FixtureCSV, FixtureYAML} {
+		t.Run(string(format), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mysqltest-fixtures")
+			if !assert.NoError(t, err, "TempDir should succeed") {
+				return
+			}
+			defer os.RemoveAll(dir)
+
+			if !assert.NoError(t, mysqld.ExportTablesAs(dir, format, "customers", "orders"), "ExportTablesAs should succeed") {
+				return
+			}
+			before, err := ioutil.ReadFile(filepath.Join(dir, "orders."+string(format)))
+			if !assert.NoError(t, err, "ReadFile should succeed") {
+				return
+			}
+
+			if _, err := db.Exec("INSERT INTO customers VALUES (3, 'carol')"); !assert.NoError(t, err, "Exec should succeed") {
+				return
+			}
+
+			// orders references customers, but the files are loaded in
+			// alphabetical order with foreign key checks disabled
+			if !assert.NoError(t, mysqld.ImportFixtures(dir), "ImportFixtures should succeed") {
+				return
+			}
+
+			var count int
+			if err := db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&count); !assert.NoError(t, err, "QueryRow should succeed") {
+				return
+			}
+			if !assert.Equal(t, 2, count, "customers are reloaded") {
+				return
+			}
+
+			if !assert.NoError(t, mysqld.ExportTablesAs(dir, format, "orders"), "ExportTablesAs should succeed") {
+				return
+			}
+			after, err := ioutil.ReadFile(filepath.Join(dir, "orders."+string(format)))
+			if !assert.NoError(t, err, "ReadFile should succeed") {
+				return
+			}
+			if !assert.Equal(t, string(before), string(after), "export is stable") {
+				return
+			}
+		})
+	}
+
+	// MySQL 8.0.13 and later report DEFAULT CURRENT_TIMESTAMP as
+	// DEFAULT_GENERATED, but the column is not generated
+	if _, err := db.Exec("CREATE TABLE events (id INT NOT NULL PRIMARY KEY, at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)"); !assert.NoError(t, err, "Exec should succeed") {
+		return
+	}
+	f, err := readFixture(db, "events")
+	if !assert.NoError(t, err, "readFixture should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"id", "at"}, f.Columns, "columns with a default are exported") {
+		return
+	}
+}
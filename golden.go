@@ -0,0 +1,217 @@
+package mysqltest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UpdateGolden makes AssertTable and AssertQuery rewrite their golden
+// files instead of comparing them. It is also enabled by an -update
+// flag defined by the test package, or by setting MYSQLTEST_UPDATE=1
+var UpdateGolden bool
+
+// maskedValue replaces the values of masked columns in golden files
+const maskedValue = "<masked>"
+
+// TestingT is the subset of testing.TB used by AssertTable and AssertQuery
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// GoldenOption is an object that can be passed to AssertTable and
+// AssertQuery
+type GoldenOption interface {
+	Name() string
+	Value() interface{}
+}
+
+// WithMask replaces the values of the given columns with "<masked>",
+// for values that change from run to run, such as auto-increment ids
+// and timestamps. NULL values are kept as is
+func WithMask(columns ...string) GoldenOption {
+	return &optionWithValue{name: "mask", value: columns}
+}
+
+// WithQueryArgs specifies the arguments for the placeholders in the
+// query passed to AssertQuery
+func WithQueryArgs(args ...interface{}) GoldenOption {
+	return &optionWithValue{name: "args", value: args}
+}
+
+// AssertTable compares the contents of table with the golden file,
+// and reports the differences via t.Errorf. Rows are sorted by primary
+// key. When UpdateGolden is enabled, the golden file is rewritten
+// instead. Returns true if the contents match
+func AssertTable(t TestingT, db Queryer, table, golden string, options ...GoldenOption) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	f, err := readFixture(db, table)
+	if err != nil {
+		t.Errorf("%s", err)
+		return false
+	}
+	return assertGolden(t, f, golden, options)
+}
+
+// AssertQuery is like AssertTable, but compares the result of query.
+// Rows are kept in the order returned by the query, so it should
+// include an ORDER BY clause
+func AssertQuery(t TestingT, db Queryer, query, golden string, options ...GoldenOption) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	var args []interface{}
+	for _, o := range options {
+		if o.Name() == "args" {
+			list, _ := o.Value().([]interface{})
+			args = append(args, list...)
+		}
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Errorf("failed to execute '%s': %s", query, err)
+		return false
+	}
+	defer rows.Close()
+
+	f, err := scanFixture(rows)
+	if err != nil {
+		t.Errorf("failed to read result of '%s': %s", query, err)
+		return false
+	}
+	return assertGolden(t, f, golden, options)
+}
+
+func shouldUpdateGolden() bool {
+	if UpdateGolden {
+		return true
+	}
+	if v, err := strconv.ParseBool(os.Getenv("MYSQLTEST_UPDATE")); err == nil && v {
+		return true
+	}
+	// The flag is looked up lazily, since test packages define it
+	// after this package is initialized
+	if f := flag.Lookup("update"); f != nil {
+		return f.Value.String() == "true"
+	}
+	return false
+}
+
+func assertGolden(t TestingT, f *fixture, golden string, options []GoldenOption) bool {
+	for _, o := range options {
+		if o.Name() != "mask" {
+			continue
+		}
+		columns, _ := o.Value().([]string)
+		for _, c := range columns {
+			if !maskColumn(f, c) {
+				t.Errorf("cannot mask unknown column %s", c)
+				return false
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeFixtureYAML(&buf, f); err != nil {
+		t.Errorf("failed to encode rows: %s", err)
+		return false
+	}
+
+	if shouldUpdateGolden() {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Errorf("failed to create directory for %s: %s", golden, err)
+			return false
+		}
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Errorf("failed to write %s: %s", golden, err)
+			return false
+		}
+		return true
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.Errorf("golden file %s does not exist (set MYSQLTEST_UPDATE=1 or mysqltest.UpdateGolden to create it)", golden)
+		} else {
+			t.Errorf("failed to read %s: %s", golden, err)
+		}
+		return false
+	}
+
+	if bytes.Equal(expected, buf.Bytes()) {
+		return true
+	}
+	t.Errorf("rows do not match golden file %s:\n%s", golden, lineDiff(string(expected), buf.String()))
+	return false
+}
+
+func maskColumn(f *fixture, column string) bool {
+	for i, c := range f.Columns {
+		if c != column {
+			continue
+		}
+
+		for _, row := range f.Rows {
+			if row[i] != nil {
+				masked := maskedValue
+				row[i] = &masked
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// lineDiff shows the lines that differ between expected and actual,
+// with a few lines of context
+func lineDiff(expected, actual string) string {
+	const context = 3
+
+	a := strings.SplitAfter(strings.TrimSuffix(expected, "\n"), "\n")
+	b := strings.SplitAfter(strings.TrimSuffix(actual, "\n"), "\n")
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	start := prefix - context
+	if start < 0 {
+		start = 0
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "@@ line %d @@\n", start+1)
+	writeLines := func(mark string, lines []string) {
+		for _, l := range lines {
+			buf.WriteString(mark)
+			buf.WriteString(strings.TrimSuffix(l, "\n"))
+			buf.WriteString("\n")
+		}
+	}
+	writeLines("  ", a[start:prefix])
+	writeLines("- ", a[prefix:len(a)-suffix])
+	writeLines("+ ", b[prefix:len(b)-suffix])
+
+	end := len(a) - suffix + context
+	if end > len(a) {
+		end = len(a)
+	}
+	writeLines("  ", a[len(a)-suffix:end])
+	return buf.String()
+}
@@ -0,0 +1,133 @@
+package mysqltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestLineDiff(t *testing.T) {
+	expected := "a\nb\nc\nd\ne\nf\ng\n"
+	actual := "a\nb\nc\nd\nE\nf\ng\n"
+
+	diff := lineDiff(expected, actual)
+	if !assert.Equal(t, "@@ line 2 @@\n  b\n  c\n  d\n- e\n+ E\n  f\n  g\n", diff, "diff matches") {
+		return
+	}
+}
+
+func TestShouldUpdateGolden(t *testing.T) {
+	if !assert.False(t, shouldUpdateGolden(), "disabled by default") {
+		return
+	}
+
+	os.Setenv("MYSQLTEST_UPDATE", "1")
+	defer os.Unsetenv("MYSQLTEST_UPDATE")
+	if !assert.True(t, shouldUpdateGolden(), "enabled by MYSQLTEST_UPDATE") {
+		return
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-golden")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	golden := filepath.Join(dir, "orders.golden")
+	str := func(s string) *string { return &s }
+	newFixture := func(createdAt string) *fixture {
+		return &fixture{
+			Columns: []string{"id", "created_at", "note"},
+			Rows:    [][]*string{{str("1"), str(createdAt), nil}},
+		}
+	}
+
+	UpdateGolden = true
+	ok := assertGolden(t, newFixture("2018-12-01 00:00:00"), golden, []GoldenOption{WithMask("created_at")})
+	UpdateGolden = false
+	if !assert.True(t, ok, "update should succeed") {
+		return
+	}
+
+	content, err := ioutil.ReadFile(golden)
+	if !assert.NoError(t, err, "golden file should be written") {
+		return
+	}
+	if !assert.Equal(t, "- id: \"1\"\n  created_at: \"<masked>\"\n  note: null\n", string(content), "golden file matches") {
+		return
+	}
+
+	// Masked columns may change freely
+	if !assert.True(t, assertGolden(t, newFixture("2019-01-01 12:34:56"), golden, []GoldenOption{WithMask("created_at")}), "masked rows match") {
+		return
+	}
+
+	rt := &recordingT{}
+	if !assert.False(t, assertGolden(rt, newFixture("2019-01-01 12:34:56"), golden, nil), "unmasked rows differ") {
+		return
+	}
+	if !assert.Len(t, rt.errors, 1, "one error is reported") {
+		return
+	}
+	if !assert.Contains(t, rt.errors[0], "+   created_at: \"2019-01-01 12:34:56\"", "error contains the diff") {
+		return
+	}
+
+	rt = &recordingT{}
+	if !assert.False(t, assertGolden(rt, newFixture(""), golden, []GoldenOption{WithMask("updated_at")}), "unknown mask fails") {
+		return
+	}
+}
+
+func TestAssertTable(t *testing.T) {
+	mysqld, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE orders (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, item VARCHAR(64) NOT NULL, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)",
+		"INSERT INTO orders (item) VALUES ('apple'), ('banana')",
+	} {
+		if _, err := db.Exec(stmt); !assert.NoError(t, err, "Exec should succeed") {
+			return
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "mysqltest-golden")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	golden := filepath.Join(dir, "orders.golden")
+	UpdateGolden = true
+	AssertTable(t, db, "orders", golden, WithMask("created_at"))
+	UpdateGolden = false
+
+	if !AssertTable(t, db, "orders", golden, WithMask("created_at")) {
+		return
+	}
+	if !AssertQuery(t, db, "SELECT id, item, created_at FROM orders WHERE id > ? ORDER BY id", golden, WithMask("created_at"), WithQueryArgs(0)) {
+		return
+	}
+}
@@ -1,6 +1,12 @@
 package mysqltest
 
-import "os/exec"
+import (
+	"crypto/tls"
+	"database/sql"
+	"io"
+	"os/exec"
+	"sync"
+)
 
 // DatasourceOption is an object that can be passed to the
 // various methods that generate datasource names
@@ -21,9 +27,122 @@ type MysqldConfig struct {
 	Socket         string
 	TmpDir         string
 
+	// Each listener is enabled independently:
+	//
+	// The unix socket (Socket) is used unless SkipSocket is set. mysqld
+	// always creates its socket file, so SkipSocket only keeps DSN and
+	// friends from using it.
+	//
+	// IPv4 TCP (BindAddress:Port) is enabled unless SkipNetworking is
+	// set. If BindAddress is empty, mysqld listens on all interfaces as
+	// it always has, and clients connect to 127.0.0.1.
+	//
+	// IPv6 TCP (BindAddress6:Port6) is enabled when BindAddress6 is set,
+	// such as "::1", regardless of SkipNetworking. mysqld listens on a
+	// single port, so when both IPv4 and IPv6 are enabled Port6 is the
+	// same as Port. Listening on both a specific BindAddress and
+	// BindAddress6 requires MySQL 8.0.13 or later.
+	//
+	// Ports that are not specified are allocated automatically
+	SkipSocket   bool
+	BindAddress6 string
+	Port6        int
+
 	AutoStart      int
 	MysqlInstallDb string
 	Mysqld         string
+
+	// LogOutput, if non-nil, receives a copy of everything mysqld
+	// writes to its log. Use LoggerWriter to forward it to a testing.TB
+	LogOutput io.Writer
+
+	// SlowQueryLog enables the slow query log with long_query_time=0
+	// and log_queries_not_using_indexes, so that every statement is
+	// recorded in SlowQueryLogFile
+	SlowQueryLog     bool
+	SlowQueryLogFile string
+
+	// PerformanceSchema explicitly enables performance_schema and the
+	// statement digest consumer. Most MySQL versions enable these by
+	// default, but MariaDB does not
+	PerformanceSchema bool
+
+	// TLS generates a throwaway CA, server and client certificates in
+	// BaseDir/etc and configures mysqld to use them. DSN() will then
+	// include the tls parameter pointing to a matching tls.Config.
+	// RequireSecureTransport additionally rejects insecure connections
+	TLS                    bool
+	RequireSecureTransport bool
+
+	// Roles and Users are created after the server has started.
+	// Roles are only supported by MySQL 8.0 and later
+	Roles []*Role
+	Users []*User
+
+	// SecureRoot bootstraps the server with a password for root@localhost
+	// instead of leaving it empty. RootPassword is used if specified,
+	// otherwise a random password is generated
+	SecureRoot   bool
+	RootPassword string
+
+	// DefaultAuthPlugin is used for users created without an explicit
+	// plugin. It sets default_authentication_plugin, or
+	// authentication_policy on MySQL 8.4 and later, where the former
+	// was removed
+	DefaultAuthPlugin string
+
+	// PluginLoad is a list of plugins to be loaded at startup via
+	// plugin-load-add, such as "auth_socket.so"
+	PluginLoad []string
+
+	// Mysqlx enables the X Plugin (MySQL 5.7.12 and later). When
+	// disabled, which is the default, the plugin is turned off so that
+	// parallel instances do not collide on the default port 33060.
+	// MysqlxPort is allocated automatically when networking is enabled,
+	// and MysqlxSocket defaults to a socket under TmpDir.
+	// With MySQL 5.7, "mysqlx.so" must also be added to PluginLoad
+	Mysqlx       bool
+	MysqlxPort   int
+	MysqlxSocket string
+
+	// PortLockDir is the directory where lock files for automatically
+	// allocated ports are created. Processes sharing this directory
+	// never receive the same port. Defaults to DefaultPortLockDir
+	PortLockDir string
+
+	// Migrations is a directory containing migration files named like
+	// "0001_init.up.sql" and "0001_init.down.sql". When set, pending
+	// migrations are applied to the "test" database after each Start,
+	// and the current version is tracked in the schema_migrations table.
+	Migrations string
+
+	// MigrationCacheDir, if set, caches the data directory produced by
+	// Migrations. The first instance saves it under a key derived from
+	// the migration files, the server version and the accounts, and
+	// later instances with the same key copy it during Setup instead of
+	// initializing a new one. It is ignored when CopyDataFrom is set or
+	// when SecureRoot generates a random password
+	MigrationCacheDir string
+
+	// Fixtures is a directory containing files written by ExportTables.
+	// When set, they are loaded into the "test" database after each
+	// Start (after migrations), and again by Reset
+	Fixtures string
+
+	// Resettable makes Start record the schemas, the rows in their
+	// tables and the global variables once it is done, so that Reset
+	// can return to that state. Reading every row takes time and
+	// memory with large seed data, so it is disabled by default
+	Resettable bool
+
+	// FixtureCSVNull is the value that represents NULL in CSV fixtures.
+	// Defaults to \N, as in LOAD DATA INFILE, which means that a column
+	// containing the text \N cannot be exported as CSV
+	FixtureCSVNull string
+
+	// CloneOptions controls how CopyDataFrom is copied to DataDir.
+	// See Clone
+	CloneOptions *CloneOptions
 }
 
 // TestMysqld is the main struct that handles the execution of mysqld
@@ -33,4 +152,44 @@ type TestMysqld struct {
 	DefaultsFile string
 	Guards       []func()
 	LogFile      string
+
+	// TLSConfigName is the name under which the tls.Config for this
+	// instance is registered with the mysql driver
+	TLSConfigName string
+
+	// RootPassword is the password for root@localhost, if
+	// config.SecureRoot is enabled. DSN uses it when connecting as root
+	RootPassword string
+
+	tlsConfig        *tls.Config
+	serverPubKeyName string
+	bindAddressSet   bool
+	version          *serverVersion
+	autoPort         bool
+	releasePort      func()
+	logOffset        int64
+
+	// guardsMu protects Guards, which helpers may extend from
+	// parallel tests
+	guardsMu sync.Mutex
+
+	usersMu sync.Mutex
+	users   map[userKey]*User
+
+	dbMu sync.Mutex
+	dbs  map[string]*sql.DB
+
+	// state right after Start, restored by Reset
+	initialSchemas map[string]bool
+	initialGlobals map[string]sql.NullString
+	initialData    map[string]map[string]*fixture
+	snapshotErr    error
+
+	cloneReport *CloneReport
+
+	// cachedDataDir is the data directory copied from
+	// config.MigrationCacheDir, and migrationCache is where the data
+	// directory is to be saved after the migrations
+	cachedDataDir  string
+	migrationCache string
 }
@@ -0,0 +1,161 @@
+package mysqltest
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// serverVersion is the version of mysqld, as reported by
+// `mysqld --help --verbose`
+type serverVersion struct {
+	major   int
+	minor   int
+	patch   int
+	mariadb bool
+}
+
+var serverVersionPattern = regexp.MustCompile(`\bVer\s+(\d+)\.(\d+)\.(\d+)(\S*)`)
+
+// parseServerVersion extracts the version from the output of mysqld.
+// It returns nil if the version cannot be found
+func parseServerVersion(out []byte) *serverVersion {
+	m := serverVersionPattern.FindSubmatch(out)
+	if m == nil {
+		return nil
+	}
+
+	v := &serverVersion{mariadb: bytes.Contains(bytes.ToLower(m[4]), []byte("mariadb"))}
+	v.major, _ = strconv.Atoi(string(m[1]))
+	v.minor, _ = strconv.Atoi(string(m[2]))
+	v.patch, _ = strconv.Atoi(string(m[3]))
+	return v
+}
+
+func (v *serverVersion) atLeast(major, minor, patch int) bool {
+	if v.major != major {
+		return v.major > major
+	}
+	if v.minor != minor {
+		return v.minor > minor
+	}
+	return v.patch >= patch
+}
+
+func (v *serverVersion) String() string {
+	s := strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+	if v.mariadb {
+		s += "-MariaDB"
+	}
+	return s
+}
+
+// supportsMultipleBindAddresses returns true if bind-address accepts a
+// comma separated list
+func (v *serverVersion) supportsMultipleBindAddresses() bool {
+	if v.mariadb {
+		return v.atLeast(10, 11, 0)
+	}
+	return v.atLeast(8, 0, 13)
+}
+
+// bindsIPv6ByDefault returns true if mysqld listens on all IPv4 and
+// IPv6 interfaces when bind-address is not specified
+func (v *serverVersion) bindsIPv6ByDefault() bool {
+	return v.mariadb || v.atLeast(5, 6, 6)
+}
+
+// hasSocket returns true if the unix socket may be used to connect
+func (m *TestMysqld) hasSocket() bool {
+	return !m.Config.SkipSocket
+}
+
+// hasIPv4 returns true if mysqld listens on config.BindAddress
+func (m *TestMysqld) hasIPv4() bool {
+	return !m.Config.SkipNetworking
+}
+
+// hasIPv6 returns true if mysqld listens on config.BindAddress6
+func (m *TestMysqld) hasIPv6() bool {
+	return m.Config.BindAddress6 != ""
+}
+
+// hasTCP returns true if mysqld listens on TCP at all
+func (m *TestMysqld) hasTCP() bool {
+	return m.hasIPv4() || m.hasIPv6()
+}
+
+// tcpPort returns the port that mysqld listens on
+func (m *TestMysqld) tcpPort() int {
+	if m.hasIPv4() {
+		return m.Config.Port
+	}
+	return m.Config.Port6
+}
+
+// setTCPPort records the port that mysqld listens on. mysqld uses a
+// single port for all of its TCP addresses
+func (m *TestMysqld) setTCPPort(port int) {
+	if m.hasIPv4() {
+		m.Config.Port = port
+	}
+	if m.hasIPv6() {
+		m.Config.Port6 = port
+	}
+}
+
+// bindAddress returns the value of bind-address, or an empty string if
+// mysqld should listen on all interfaces, which is what it has always
+// done unless config.BindAddress was specified
+func (m *TestMysqld) bindAddress() string {
+	switch {
+	case m.hasIPv4() && m.hasIPv6():
+		if !m.bindAddressSet {
+			return ""
+		}
+		return m.Config.BindAddress + "," + m.Config.BindAddress6
+	case m.hasIPv6():
+		return m.Config.BindAddress6
+	case m.bindAddressSet:
+		return m.Config.BindAddress
+	}
+	return ""
+}
+
+// xBindAddress returns the address for the X Protocol endpoint
+func (m *TestMysqld) xBindAddress() string {
+	if m.hasIPv4() {
+		return m.Config.BindAddress
+	}
+	return m.Config.BindAddress6
+}
+
+// checkListeners makes sure that mysqld can listen as configured.
+// version may be nil if it is unknown
+func (m *TestMysqld) checkListeners(version *serverVersion) error {
+	config := m.Config
+	if !m.hasSocket() && !m.hasTCP() {
+		return errors.New(`no listener enabled: config.SkipSocket and config.SkipNetworking are set, and config.BindAddress6 is empty`)
+	}
+
+	if !m.hasIPv4() || !m.hasIPv6() {
+		return nil
+	}
+
+	if config.Port > 0 && config.Port6 > 0 && config.Port != config.Port6 {
+		return errors.Errorf(`config.Port (%d) and config.Port6 (%d) differ, but mysqld listens on a single port for all TCP addresses`, config.Port, config.Port6)
+	}
+
+	if version == nil {
+		return nil
+	}
+	if m.bindAddressSet && !version.supportsMultipleBindAddresses() {
+		return errors.Errorf(`mysqld %s cannot bind to both %s and %s (MySQL 8.0.13 or MariaDB 10.11 required): leave config.BindAddress empty to listen on all interfaces, or disable one of the listeners`, version, config.BindAddress, config.BindAddress6)
+	}
+	if !m.bindAddressSet && !version.bindsIPv6ByDefault() {
+		return errors.Errorf(`mysqld %s does not listen on IPv6 and IPv4 at the same time (MySQL 5.6.6 required)`, version)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	testcases := map[string]*serverVersion{
+		"/usr/sbin/mysqld  Ver 8.0.32 for Linux on x86_64 (MySQL Community Server - GPL)": {major: 8, minor: 0, patch: 32},
+		"mysqld  Ver 5.6.51 for linux-glibc2.12 on x86_64":                                {major: 5, minor: 6, patch: 51},
+		"mysqld  Ver 10.6.12-MariaDB-0ubuntu0.22.04.1 for debian-linux-gnu on x86_64":     {major: 10, minor: 6, patch: 12, mariadb: true},
+	}
+
+	for out, expected := range testcases {
+		if !assert.Equal(t, expected, parseServerVersion([]byte(out+"\nCopyright (c) 2000\n")), "version matches for %s", out) {
+			return
+		}
+	}
+
+	if !assert.Nil(t, parseServerVersion([]byte("unknown")), "version is nil") {
+		return
+	}
+}
+
+func TestBindAddress(t *testing.T) {
+	testcases := []struct {
+		config   MysqldConfig
+		explicit bool
+		expected string
+	}{
+		{config: MysqldConfig{SkipNetworking: true}, expected: ""},
+		{config: MysqldConfig{BindAddress: "127.0.0.1"}, expected: ""},
+		{config: MysqldConfig{BindAddress: "127.0.0.1"}, explicit: true, expected: "127.0.0.1"},
+		{config: MysqldConfig{SkipNetworking: true, BindAddress6: "::1"}, expected: "::1"},
+		{config: MysqldConfig{BindAddress: "127.0.0.1", BindAddress6: "::1"}, expected: ""},
+		{config: MysqldConfig{BindAddress: "127.0.0.1", BindAddress6: "::1"}, explicit: true, expected: "127.0.0.1,::1"},
+	}
+
+	for i, tc := range testcases {
+		config := tc.config
+		m := &TestMysqld{Config: &config, bindAddressSet: tc.explicit}
+		if !assert.Equal(t, tc.expected, m.bindAddress(), "bind-address matches for case %d", i) {
+			return
+		}
+	}
+}
+
+func TestCheckListeners(t *testing.T) {
+	mysql80 := &serverVersion{major: 8, minor: 0, patch: 32}
+	mysql57 := &serverVersion{major: 5, minor: 7, patch: 44}
+
+	testcases := []struct {
+		config   MysqldConfig
+		explicit bool
+		version  *serverVersion
+		valid    bool
+	}{
+		{config: MysqldConfig{SkipNetworking: true}, valid: true},
+		{config: MysqldConfig{SkipNetworking: true, SkipSocket: true}, valid: false},
+		{config: MysqldConfig{SkipNetworking: true, SkipSocket: true, BindAddress6: "::1"}, valid: true},
+		{config: MysqldConfig{BindAddress6: "::1", Port: 3306, Port6: 3307}, valid: false},
+		{config: MysqldConfig{BindAddress: "127.0.0.1", BindAddress6: "::1"}, explicit: true, version: mysql80, valid: true},
+		{config: MysqldConfig{BindAddress: "127.0.0.1", BindAddress6: "::1"}, explicit: true, version: mysql57, valid: false},
+		{config: MysqldConfig{BindAddress: "127.0.0.1", BindAddress6: "::1"}, version: mysql57, valid: true},
+		{config: MysqldConfig{BindAddress: "127.0.0.1", BindAddress6: "::1"}, version: &serverVersion{major: 5, minor: 5, patch: 62}, valid: false},
+	}
+
+	for i, tc := range testcases {
+		config := tc.config
+		m := &TestMysqld{Config: &config, bindAddressSet: tc.explicit}
+		err := m.checkListeners(tc.version)
+		if !assert.Equal(t, tc.valid, err == nil, "result matches for case %d (%v)", i, err) {
+			return
+		}
+	}
+}
+
+func TestDSNDisabledListeners(t *testing.T) {
+	m := &TestMysqld{
+		Config: &MysqldConfig{
+			SkipNetworking: true,
+			SkipSocket:     true,
+			Socket:         "/tmp/mysql.sock",
+			BindAddress6:   "::1",
+			Port6:          13306,
+		},
+	}
+
+	if !assert.Equal(t, "root:@tcp6([::1]:13306)/test", m.DSN(), "IPv6 is the default") {
+		return
+	}
+
+	for _, proto := range []string{"unix", "tcp"} {
+		if _, err := m.DSNE(WithProto(proto)); !assert.Error(t, err, "DSNE should fail for %s", proto) {
+			return
+		}
+		if !assert.Equal(t, "root:@tcp6([::1]:13306)/test", m.DSN(WithProto(proto)), "DSN falls back to the default for %s", proto) {
+			return
+		}
+	}
+
+	m.Config.BindAddress6 = ""
+	m.Config.SkipSocket = false
+	if _, err := m.DSNE(WithProto("tcp6")); !assert.Error(t, err, "DSNE should fail for tcp6") {
+		return
+	}
+	if _, err := m.DSNE(WithProto("tcp6"), WithHost("::1"), WithPort(3306)); !assert.NoError(t, err, "DSNE should succeed with an explicit address") {
+		return
+	}
+}
+
+func TestIPv6Only(t *testing.T) {
+	config := NewConfig()
+	config.SkipSocket = true
+	config.BindAddress6 = "::1"
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	if !assert.Empty(t, mysqld.Address(), "IPv4 address should be empty") {
+		return
+	}
+	if !assert.Empty(t, mysqld.Socket(), "socket should be empty") {
+		return
+	}
+	if !assert.Equal(t, fmt.Sprintf("[::1]:%d", config.Port6), mysqld.Address6(), "IPv6 address matches") {
+		return
+	}
+
+	db, err := sql.Open("mysql", mysqld.DSN())
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	if !assert.NoError(t, db.Ping(), "connecting over IPv6 should succeed") {
+		return
+	}
+
+	ipv4, err := sql.Open("mysql", mysqld.DSN(WithProto("tcp"), WithHost("127.0.0.1"), WithPort(config.Port6)))
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer ipv4.Close()
+
+	if !assert.Error(t, ipv4.Ping(), "IPv4 should not be listening") {
+		return
+	}
+}
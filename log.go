@@ -0,0 +1,220 @@
+package mysqltest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LogEntry represents a single line in the mysqld error log
+type LogEntry struct {
+	Time      time.Time
+	Thread    int64
+	Severity  string // "System", "Note", "Warning", "ERROR", etc
+	Code      string // MY-XXXXXX error code (MySQL 8.x only)
+	Subsystem string // "Server", "InnoDB", etc (MySQL 8.x only)
+	Message   string
+	Raw       string
+}
+
+// Logger is the minimal interface required to receive log lines.
+// testing.TB satisfies this interface
+type Logger interface {
+	Logf(string, ...interface{})
+}
+
+var (
+	// 2018-12-01T00:00:00.000000Z 0 [System] [MY-010116] [Server] message
+	logLine8x = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\S+)\s+(\d+)\s+\[([^\]]+)\]\s+\[(MY-\d+)\]\s+\[([^\]]+)\]\s+(.*)$`)
+	// 2018-12-01T00:00:00.000000Z 0 [Note] message
+	logLine57 = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\S+)\s+(\d+)\s+\[([^\]]+)\]\s+(.*)$`)
+	// 2018-12-01 00:00:00 12345 [Note] message
+	logLine56 = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\s+(\d+)\s+\[([^\]]+)\]\s+(.*)$`)
+	// 181201  0:00:00 [Note] message
+	logLine55 = regexp.MustCompile(`^(\d{6})\s+(\d{1,2}:\d{2}:\d{2})\s+(?:\[([^\]]+)\]\s+)?(.*)$`)
+)
+
+// ParseLogLine parses a single line from the mysqld error log.
+// Both MySQL 5.x and 8.x formats are recognized. Lines that do not
+// match any known format (e.g. continuation lines of a multi-line
+// message) are returned with only Message and Raw populated.
+func ParseLogLine(line string) *LogEntry {
+	line = strings.TrimRight(line, "\r\n")
+	entry := &LogEntry{Raw: line, Message: line}
+
+	if m := logLine8x.FindStringSubmatch(line); m != nil {
+		entry.Time, _ = time.Parse(time.RFC3339Nano, m[1])
+		entry.Thread, _ = strconv.ParseInt(m[2], 10, 64)
+		entry.Severity = m[3]
+		entry.Code = m[4]
+		entry.Subsystem = m[5]
+		entry.Message = m[6]
+		return entry
+	}
+
+	if m := logLine57.FindStringSubmatch(line); m != nil {
+		entry.Time, _ = time.Parse(time.RFC3339Nano, m[1])
+		entry.Thread, _ = strconv.ParseInt(m[2], 10, 64)
+		entry.Severity = m[3]
+		entry.Message = m[4]
+		return entry
+	}
+
+	if m := logLine56.FindStringSubmatch(line); m != nil {
+		entry.Time, _ = time.ParseInLocation("2006-01-02 15:04:05", m[1], time.Local)
+		entry.Thread, _ = strconv.ParseInt(m[2], 10, 64)
+		entry.Severity = m[3]
+		entry.Message = m[4]
+		return entry
+	}
+
+	if m := logLine55.FindStringSubmatch(line); m != nil {
+		clock := m[2]
+		if len(clock) == 7 { // " 0:00:00" -> "00:00:00"
+			clock = "0" + clock
+		}
+		entry.Time, _ = time.ParseInLocation("060102 15:04:05", m[1]+" "+clock, time.Local)
+		entry.Severity = m[3]
+		entry.Message = m[4]
+		return entry
+	}
+
+	return entry
+}
+
+// Logs returns a channel that receives parsed entries from the mysqld
+// log file, starting from the beginning of the file. New entries are
+// delivered as mysqld writes them. The channel is closed when ctx
+// is canceled, or when the log file cannot be read anymore.
+func (m *TestMysqld) Logs(ctx context.Context) <-chan *LogEntry {
+	ch := make(chan *LogEntry)
+	go m.tailLog(ctx, ch, 0)
+	return ch
+}
+
+// LogsSinceStart is like Logs, but skips the entries written before the
+// last call to Start, such as those of a previous run of mysqld or of
+// an attempt that failed to bind its port.
+func (m *TestMysqld) LogsSinceStart(ctx context.Context) <-chan *LogEntry {
+	ch := make(chan *LogEntry)
+	go m.tailLog(ctx, ch, m.logOffset)
+	return ch
+}
+
+func (m *TestMysqld) tailLog(ctx context.Context, ch chan *LogEntry, offset int64) {
+	defer close(ch)
+
+	file, err := os.Open(m.LogFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+
+	rdr := bufio.NewReader(file)
+	var partial bytes.Buffer
+	for {
+		line, err := rdr.ReadString('\n')
+		partial.WriteString(line)
+		if err == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- ParseLogLine(partial.String()):
+			}
+			partial.Reset()
+			continue
+		}
+
+		if err != io.EOF {
+			return
+		}
+
+		// Wait for mysqld to write some more
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// WaitForLog blocks until an entry whose raw line matches the regular
+// expression pattern appears in the mysqld log, and returns that entry.
+// Entries written before WaitForLog is called are also considered.
+func (m *TestMysqld) WaitForLog(ctx context.Context, pattern string) (*LogEntry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to compile pattern`)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for entry := range m.Logs(ctx) {
+		if re.MatchString(entry.Raw) {
+			return entry, nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, `failed to find log entry matching '%s'`, pattern)
+	}
+	return nil, errors.Errorf(`failed to find log entry matching '%s'`, pattern)
+}
+
+type loggerWriter struct {
+	mu     sync.Mutex
+	logger Logger
+	buf    bytes.Buffer
+}
+
+// LoggerWriter creates an io.Writer that sends each line written to it
+// to l.Logf. It can be assigned to config.LogOutput to forward the
+// mysqld log to a testing.TB
+func LoggerWriter(l Logger) io.Writer {
+	return &loggerWriter{logger: l}
+}
+
+func (w *loggerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf.Next(i + 1)
+		w.logger.Logf("%s", bytes.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// syncWriter serializes writes from the stdout/stderr copiers
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
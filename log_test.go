@@ -0,0 +1,128 @@
+package mysqltest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLine(t *testing.T) {
+	testcases := []struct {
+		Line     string
+		Expected LogEntry
+	}{
+		{
+			Line: "2018-12-01T10:20:30.123456Z 0 [System] [MY-010116] [Server] /usr/sbin/mysqld (mysqld 8.0.13) starting as process 1",
+			Expected: LogEntry{
+				Time:      time.Date(2018, 12, 1, 10, 20, 30, 123456000, time.UTC),
+				Severity:  "System",
+				Code:      "MY-010116",
+				Subsystem: "Server",
+				Message:   "/usr/sbin/mysqld (mysqld 8.0.13) starting as process 1",
+			},
+		},
+		{
+			Line: "2018-12-01T10:20:30.123456Z 12 [Warning] InnoDB: New log files created, LSN=45790",
+			Expected: LogEntry{
+				Time:     time.Date(2018, 12, 1, 10, 20, 30, 123456000, time.UTC),
+				Thread:   12,
+				Severity: "Warning",
+				Message:  "InnoDB: New log files created, LSN=45790",
+			},
+		},
+		{
+			Line: "2018-12-01 10:20:30 4321 [Note] /usr/sbin/mysqld: ready for connections.",
+			Expected: LogEntry{
+				Time:     time.Date(2018, 12, 1, 10, 20, 30, 0, time.Local),
+				Thread:   4321,
+				Severity: "Note",
+				Message:  "/usr/sbin/mysqld: ready for connections.",
+			},
+		},
+		{
+			Line: "181201  1:20:30 [ERROR] Aborting",
+			Expected: LogEntry{
+				Time:     time.Date(2018, 12, 1, 1, 20, 30, 0, time.Local),
+				Severity: "ERROR",
+				Message:  "Aborting",
+			},
+		},
+		{
+			Line: "Version: '8.0.13'  socket: '/tmp/mysql.sock'  port: 0  MySQL Community Server - GPL.",
+			Expected: LogEntry{
+				Message: "Version: '8.0.13'  socket: '/tmp/mysql.sock'  port: 0  MySQL Community Server - GPL.",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		entry := ParseLogLine(tc.Line + "\n")
+		tc.Expected.Raw = tc.Line
+		if !assert.True(t, tc.Expected.Time.Equal(entry.Time), "time matches for %s", tc.Line) {
+			return
+		}
+		entry.Time = tc.Expected.Time
+		if !assert.Equal(t, &tc.Expected, entry, "entry matches for %s", tc.Line) {
+			return
+		}
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Logf(f string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(f, args...))
+}
+
+func TestLoggerWriter(t *testing.T) {
+	var l testLogger
+	w := LoggerWriter(&l)
+	w.Write([]byte("hello\nwor"))
+	w.Write([]byte("ld\n"))
+	if !assert.Equal(t, []string{"hello", "world"}, l.lines, "lines match") {
+		return
+	}
+}
+
+func TestLogsSinceStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-log")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	previous := "2018-12-01T00:00:00.000000Z 0 [Note] previous run\n"
+	current := "2018-12-01T00:01:00.000000Z 0 [Note] current run\n"
+	logfile := filepath.Join(dir, "mysqld.log")
+	if !assert.NoError(t, ioutil.WriteFile(logfile, []byte(previous+current), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	m := &TestMysqld{LogFile: logfile, logOffset: int64(len(previous))}
+
+	first := func(ch <-chan *LogEntry) string {
+		entry := <-ch
+		if entry == nil {
+			return ""
+		}
+		return entry.Message
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if !assert.Equal(t, "previous run", first(m.Logs(ctx)), "Logs starts at the beginning of the file") {
+		return
+	}
+	if !assert.Equal(t, "current run", first(m.LogsSinceStart(ctx)), "LogsSinceStart skips previous runs") {
+		return
+	}
+}
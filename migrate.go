@@ -0,0 +1,271 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Migration is a single schema migration, made of an up file and an
+// optional down file
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string // path to the .up.sql file
+	Down    string // path to the .down.sql file, if any
+}
+
+// 0001_init.up.sql, 0001_init.down.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ReadMigrations reads the migration files in dir, and returns them
+// sorted by version. Files that do not follow the naming convention
+// are ignored
+func ReadMigrations(dir string) ([]*Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to read migrations directory`)
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+
+		match := migrationFile.FindStringSubmatch(fi.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, `invalid migration version in %s`, fi.Name())
+		}
+		if version == 0 {
+			return nil, errors.Errorf(`migration version must be greater than 0 in %s`, fi.Name())
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mg
+		} else if mg.Name != match[2] {
+			return nil, errors.Errorf(`duplicate migration version %d (%s and %s)`, version, mg.Name, match[2])
+		}
+
+		path := filepath.Join(dir, fi.Name())
+		if match[3] == "up" {
+			mg.Up = path
+		} else {
+			mg.Down = path
+		}
+	}
+
+	list := make([]*Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" {
+			return nil, errors.Errorf(`migration %d (%s) has no up file`, mg.Version, mg.Name)
+		}
+		list = append(list, mg)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// migrationDB returns the handle used to apply migrations, and makes
+// sure that the schema_migrations table exists. The table layout is
+// the same as golang-migrate's, so either tool can pick up the state
+func (m *TestMysqld) migrationDB() (*sql.DB, error) {
+	db, err := m.DB(WithMultiStatements(true))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)"); err != nil {
+		return nil, errors.Wrap(err, `failed to create schema_migrations table`)
+	}
+	return db, nil
+}
+
+func migrationVersion(db *sql.DB) (uint64, bool, error) {
+	var version uint64
+	var dirty bool
+	switch err := db.QueryRow("SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty); err {
+	case nil:
+		return version, dirty, nil
+	case sql.ErrNoRows:
+		return 0, false, nil
+	default:
+		return 0, false, errors.Wrap(err, `failed to read schema_migrations`)
+	}
+}
+
+func setMigrationVersion(db *sql.DB, version uint64, dirty bool) error {
+	if _, err := db.Exec("DELETE FROM schema_migrations"); err != nil {
+		return errors.Wrap(err, `failed to update schema_migrations`)
+	}
+	if version == 0 && !dirty {
+		return nil
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)", version, dirty); err != nil {
+		return errors.Wrap(err, `failed to update schema_migrations`)
+	}
+	return nil
+}
+
+// runMigrationFile executes the statements in filename, marking the
+// database as dirty at version while they run
+func runMigrationFile(db *sql.DB, filename string, version, result uint64) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, `failed to read migration file`)
+	}
+
+	if err := setMigrationVersion(db, version, true); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := db.Exec(string(content)); err != nil {
+			return errors.Wrapf(err, `failed to execute %s`, filename)
+		}
+	}
+	return setMigrationVersion(db, result, false)
+}
+
+// MigrationVersion returns the version of the last applied migration,
+// or 0 if none have been applied
+func (m *TestMysqld) MigrationVersion() (uint64, error) {
+	db, err := m.migrationDB()
+	if err != nil {
+		return 0, err
+	}
+
+	version, dirty, err := migrationVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return version, errors.Errorf(`database is dirty at version %d`, version)
+	}
+	return version, nil
+}
+
+// Migrate applies all pending migrations in config.Migrations. It does
+// nothing if the directory contains no migrations, so that the version
+// recorded in the database is never reverted
+func (m *TestMysqld) Migrate() error {
+	list, err := m.readMigrations()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return m.MigrateTo(list[len(list)-1].Version)
+}
+
+// MigrateTo applies or reverts migrations in config.Migrations until
+// the database is at the given version. Version 0 reverts everything
+func (m *TestMysqld) MigrateTo(version uint64) error {
+	list, err := m.readMigrations()
+	if err != nil {
+		return err
+	}
+
+	db, err := m.migrationDB()
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := migrationVersion(db)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return errors.Errorf(`database is dirty at version %d`, current)
+	}
+
+	if version != 0 && findMigration(list, version) < 0 {
+		return errors.Errorf(`no migration with version %d`, version)
+	}
+	if current != 0 && findMigration(list, current) < 0 {
+		return errors.Errorf(`database is at version %d, which has no migration file`, current)
+	}
+
+	// Up
+	for _, mg := range list {
+		if mg.Version <= current || mg.Version > version {
+			continue
+		}
+		if err := runMigrationFile(db, mg.Up, mg.Version, mg.Version); err != nil {
+			return err
+		}
+	}
+
+	// Down
+	for i := len(list) - 1; i >= 0; i-- {
+		mg := list[i]
+		if mg.Version > current || mg.Version <= version {
+			continue
+		}
+		if mg.Down == "" {
+			return errors.Errorf(`migration %d (%s) has no down file`, mg.Version, mg.Name)
+		}
+
+		var previous uint64
+		if i > 0 {
+			previous = list[i-1].Version
+		}
+		if err := runMigrationFile(db, mg.Down, mg.Version, previous); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the most recently applied migration
+func (m *TestMysqld) MigrateDown() error {
+	list, err := m.readMigrations()
+	if err != nil {
+		return err
+	}
+
+	current, err := m.MigrationVersion()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return errors.New(`no migrations have been applied`)
+	}
+
+	var previous uint64
+	for _, mg := range list {
+		if mg.Version >= current {
+			break
+		}
+		previous = mg.Version
+	}
+	return m.MigrateTo(previous)
+}
+
+func (m *TestMysqld) readMigrations() ([]*Migration, error) {
+	if m.Config.Migrations == "" {
+		return nil, errors.New(`config.Migrations is not set`)
+	}
+	return ReadMigrations(m.Config.Migrations)
+}
+
+func findMigration(list []*Migration, version uint64) int {
+	for i, mg := range list {
+		if mg.Version == version {
+			return i
+		}
+	}
+	return -1
+}
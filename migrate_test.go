@@ -0,0 +1,136 @@
+package mysqltest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMigrations(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mysqltest-migrations")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		t.FailNow()
+	}
+
+	for name, content := range files {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), "WriteFile should succeed") {
+			t.FailNow()
+		}
+	}
+	return dir
+}
+
+func TestReadMigrations(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0002_orders.up.sql":   "CREATE TABLE orders (id INT NOT NULL PRIMARY KEY)",
+		"0002_orders.down.sql": "DROP TABLE orders",
+		"0001_init.up.sql":     "CREATE TABLE users (id INT NOT NULL PRIMARY KEY)",
+		"README.md":            "not a migration",
+	})
+	defer os.RemoveAll(dir)
+
+	list, err := ReadMigrations(dir)
+	if !assert.NoError(t, err, "ReadMigrations should succeed") {
+		return
+	}
+	if !assert.Len(t, list, 2, "two migrations") {
+		return
+	}
+	if !assert.Equal(t, uint64(1), list[0].Version, "sorted by version") {
+		return
+	}
+	if !assert.Equal(t, "init", list[0].Name, "name matches") {
+		return
+	}
+	if !assert.Empty(t, list[0].Down, "no down file") {
+		return
+	}
+	if !assert.Equal(t, filepath.Join(dir, "0002_orders.down.sql"), list[1].Down, "down file matches") {
+		return
+	}
+
+	t.Run("Missing up file", func(t *testing.T) {
+		dir := writeMigrations(t, map[string]string{
+			"0001_init.down.sql": "DROP TABLE users",
+		})
+		defer os.RemoveAll(dir)
+
+		_, err := ReadMigrations(dir)
+		if !assert.Error(t, err, "ReadMigrations should fail") {
+			return
+		}
+	})
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"README.md": "not a migration",
+	})
+	defer os.RemoveAll(dir)
+
+	// mysqld is not running, so any attempt to touch the database fails
+	m := &TestMysqld{Config: &MysqldConfig{Migrations: dir}}
+	if !assert.NoError(t, m.Migrate(), "Migrate should do nothing") {
+		return
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_init.up.sql":     "CREATE TABLE users (id INT NOT NULL PRIMARY KEY);\nINSERT INTO users VALUES (1);",
+		"0001_init.down.sql":   "DROP TABLE users",
+		"0002_orders.up.sql":   "CREATE TABLE orders (id INT NOT NULL PRIMARY KEY)",
+		"0002_orders.down.sql": "DROP TABLE orders",
+	})
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Migrations = dir
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	version, err := mysqld.MigrationVersion()
+	if !assert.NoError(t, err, "MigrationVersion should succeed") {
+		return
+	}
+	if !assert.Equal(t, uint64(2), version, "migrations are applied by Start") {
+		return
+	}
+
+	db, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+
+	if !assert.NoError(t, mysqld.MigrateDown(), "MigrateDown should succeed") {
+		return
+	}
+	if _, err := db.Exec("SELECT * FROM orders"); !assert.Error(t, err, "orders should be dropped") {
+		return
+	}
+
+	if !assert.NoError(t, mysqld.MigrateTo(0), "MigrateTo should succeed") {
+		return
+	}
+	if _, err := db.Exec("SELECT * FROM users"); !assert.Error(t, err, "users should be dropped") {
+		return
+	}
+
+	if !assert.NoError(t, mysqld.Migrate(), "Migrate should succeed") {
+		return
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); !assert.NoError(t, err, "users should exist") {
+		return
+	}
+	if !assert.Equal(t, 1, count, "users should have one row") {
+		return
+	}
+}
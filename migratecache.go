@@ -0,0 +1,168 @@
+package mysqltest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lookupMigrationCache decides whether the data directory can be
+// copied from config.MigrationCacheDir, or whether it should be saved
+// there once the migrations have been applied
+func (m *TestMysqld) lookupMigrationCache() error {
+	config := m.Config
+	m.cachedDataDir = ""
+	m.migrationCache = ""
+
+	// A random root password would never produce the same data
+	// directory twice
+	if config.MigrationCacheDir == "" || config.Migrations == "" || config.CopyDataFrom != "" || m.RootPassword != config.RootPassword {
+		return nil
+	}
+
+	key, err := m.migrationCacheKey()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(config.MigrationCacheDir, key)
+	if _, err := os.Stat(path); err == nil {
+		m.cachedDataDir = path
+		return nil
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, `failed to stat migration cache`)
+	}
+
+	m.migrationCache = path
+	return nil
+}
+
+// migrationCacheKey returns a key that identifies the data directory
+// produced by applying config.Migrations on this server. It covers the
+// server version, the accounts created before the migrations, and the
+// name and contents of every migration file
+func (m *TestMysqld) migrationCacheKey() (string, error) {
+	config := m.Config
+	list, err := m.readMigrations()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "mysqld=%s\n", config.Mysqld)
+	if m.version != nil {
+		fmt.Fprintf(h, "version=%+v\n", *m.version)
+	}
+	fmt.Fprintf(h, "install_db=%s\n", config.MysqlInstallDb)
+	fmt.Fprintf(h, "root=%s\n", m.RootPassword)
+	fmt.Fprintf(h, "tcp=%t\n", m.hasTCP())
+	fmt.Fprintf(h, "auth=%s\n", config.DefaultAuthPlugin)
+	for _, r := range config.Roles {
+		fmt.Fprintf(h, "role=%+v\n", *r)
+	}
+	for _, u := range config.Users {
+		fmt.Fprintf(h, "user=%+v\n", *u)
+	}
+
+	for _, mg := range list {
+		fmt.Fprintf(h, "migration=%d %s\n", mg.Version, mg.Name)
+		if err := hashFile(h, mg.Up); err != nil {
+			return "", err
+		}
+		if mg.Down != "" {
+			if err := hashFile(h, mg.Down); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrapf(err, `failed to read %s`, filename)
+	}
+	fmt.Fprintf(h, "%s %d\n", filepath.Base(filename), len(content))
+	h.Write(content)
+	return nil
+}
+
+// saveMigrationCache shuts mysqld down cleanly and copies the data
+// directory into config.MigrationCacheDir. The copy is renamed into
+// place, so parallel instances never see a partial one
+func (m *TestMysqld) saveMigrationCache() error {
+	path := m.migrationCache
+	m.migrationCache = ""
+
+	if err := m.shutdown(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Config.MigrationCacheDir, 0755); err != nil {
+		return errors.Wrap(err, `failed to create config.MigrationCacheDir`)
+	}
+
+	tmpdir, err := ioutil.TempDir(m.Config.MigrationCacheDir, "."+filepath.Base(path)+"-")
+	if err != nil {
+		return errors.Wrap(err, `failed to create temporary directory`)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if _, err := Clone(m.Config.DataDir, tmpdir, m.Config.CloneOptions); err != nil {
+		return errors.Wrap(err, `failed to copy data directory`)
+	}
+
+	if err := os.Rename(tmpdir, path); err != nil {
+		// Another instance saved the same data directory first
+		if _, serr := os.Stat(path); serr == nil {
+			return nil
+		}
+		return errors.Wrap(err, `failed to rename data directory`)
+	}
+	return nil
+}
+
+// shutdown stops mysqld with SIGTERM and waits for it to exit, so that
+// its data directory is consistent on disk
+func (m *TestMysqld) shutdown() error {
+	cmd := m.Command
+	if cmd == nil || cmd.Process == nil {
+		return errors.New(`mysqld is not running`)
+	}
+	m.closeDBs()
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return errors.Wrap(err, `failed to signal mysqld`)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	timeout := time.NewTimer(60 * time.Second)
+	defer timeout.Stop()
+
+	select {
+	case <-done:
+	case <-timeout.C:
+		cmd.Process.Kill()
+		<-done
+		m.Command = nil
+		return errors.New(`timeout reached before mysqld shut down`)
+	}
+
+	m.Command = nil
+	os.Remove(m.Config.PidFile)
+	return nil
+}
@@ -0,0 +1,118 @@
+package mysqltest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationCacheKey(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_init.up.sql":   "CREATE TABLE users (id INT NOT NULL PRIMARY KEY)",
+		"0001_init.down.sql": "DROP TABLE users",
+		"README.md":          "not a migration",
+	})
+	defer os.RemoveAll(dir)
+
+	m := &TestMysqld{Config: &MysqldConfig{Migrations: dir, SkipNetworking: true}}
+	key, err := m.migrationCacheKey()
+	if !assert.NoError(t, err, "migrationCacheKey should succeed") {
+		return
+	}
+
+	again, err := m.migrationCacheKey()
+	if !assert.NoError(t, err, "migrationCacheKey should succeed") {
+		return
+	}
+	if !assert.Equal(t, key, again, "key is stable") {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("still not a migration"), 0644), "WriteFile should succeed") {
+		return
+	}
+	again, err = m.migrationCacheKey()
+	if !assert.NoError(t, err, "migrationCacheKey should succeed") {
+		return
+	}
+	if !assert.Equal(t, key, again, "other files do not change the key") {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0001_init.up.sql"), []byte("CREATE TABLE users (id BIGINT NOT NULL PRIMARY KEY)"), 0644), "WriteFile should succeed") {
+		return
+	}
+	changed, err := m.migrationCacheKey()
+	if !assert.NoError(t, err, "migrationCacheKey should succeed") {
+		return
+	}
+	if !assert.NotEqual(t, key, changed, "editing a migration changes the key") {
+		return
+	}
+
+	m.Config.Users = []*User{{Name: "app"}}
+	withUser, err := m.migrationCacheKey()
+	if !assert.NoError(t, err, "migrationCacheKey should succeed") {
+		return
+	}
+	if !assert.NotEqual(t, changed, withUser, "accounts change the key") {
+		return
+	}
+}
+
+func TestMigrationCache(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_init.up.sql": "CREATE TABLE users (id INT NOT NULL PRIMARY KEY);\nINSERT INTO users VALUES (1);",
+	})
+	defer os.RemoveAll(dir)
+
+	cachedir, err := ioutil.TempDir("", "mysqltest-cache")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(cachedir)
+
+	for i := 0; i < 2; i++ {
+		config := NewConfig()
+		config.Migrations = dir
+		config.MigrationCacheDir = cachedir
+
+		mysqld, err := NewMysqld(config)
+		if !assert.NoError(t, err, "NewMysqld should succeed") {
+			return
+		}
+		defer mysqld.Stop()
+
+		if i == 0 {
+			if !assert.Nil(t, mysqld.CloneReport(), "first instance runs the migrations") {
+				return
+			}
+			entries, err := ioutil.ReadDir(cachedir)
+			if !assert.NoError(t, err, "ReadDir should succeed") {
+				return
+			}
+			if !assert.Len(t, entries, 1, "data directory should be cached") {
+				return
+			}
+		} else {
+			if !assert.NotNil(t, mysqld.CloneReport(), "second instance copies the cache") {
+				return
+			}
+		}
+
+		db, err := mysqld.DB()
+		if !assert.NoError(t, err, "DB should succeed") {
+			return
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); !assert.NoError(t, err, "users should exist") {
+			return
+		}
+		if !assert.Equal(t, 1, count, "users should have one row") {
+			return
+		}
+	}
+}
@@ -3,10 +3,11 @@ package mysqltest
 import (
 	"bytes"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/url"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -15,8 +16,7 @@ import (
 	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql" // for mysql
-	"github.com/lestrrat-go/tcputil"
+	"github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 )
 
@@ -32,6 +32,27 @@ func NewConfig() *MysqldConfig {
 func NewMysqld(config *MysqldConfig) (*TestMysqld, error) {
 	guards := []func(){}
 
+	// On failure, remove the temporary directory, release the ports and
+	// stop mysqld if it was started, since the caller cannot call Stop
+	var mysqld *TestMysqld
+	var releasePort func()
+	succeeded := false
+	defer func() {
+		if succeeded {
+			return
+		}
+		if mysqld != nil && mysqld.Guards != nil {
+			mysqld.Stop()
+			return
+		}
+		if releasePort != nil {
+			releasePort()
+		}
+		for _, g := range guards {
+			g()
+		}
+	}()
+
 	if config == nil {
 		config = NewConfig()
 	}
@@ -84,24 +105,61 @@ func NewMysqld(config *MysqldConfig) (*TestMysqld, error) {
 		config.DataDir = filepath.Join(config.BaseDir, "var")
 	}
 
-	if !config.SkipNetworking {
-		if config.BindAddress == "" {
-			config.BindAddress = "127.0.0.1"
+	if config.PortLockDir == "" {
+		config.PortLockDir = DefaultPortLockDir
+	}
+
+	mysqld = &TestMysqld{
+		Config:         config,
+		DefaultsFile:   filepath.Join(config.BaseDir, "etc", "my.cnf"),
+		bindAddressSet: config.BindAddress != "",
+	}
+
+	if mysqld.hasIPv4() && config.BindAddress == "" {
+		config.BindAddress = "127.0.0.1"
+	}
+
+	var autoPort bool
+	if mysqld.hasTCP() {
+		// mysqld listens on a single port for both IPv4 and IPv6
+		if mysqld.hasIPv4() && mysqld.hasIPv6() && config.Port <= 0 {
+			config.Port = config.Port6
+		}
+
+		if mysqld.tcpPort() <= 0 {
+			p, release, err := ReservePort(config.PortLockDir)
+			if err != nil {
+				return nil, err
+			}
+			mysqld.setTCPPort(p)
+			autoPort = true
+			releasePort = release
+		} else if mysqld.hasIPv4() && mysqld.hasIPv6() && config.Port6 <= 0 {
+			config.Port6 = config.Port
 		}
 
-		if config.Port <= 0 {
-			p, err := tcputil.EmptyPort()
+		if config.Mysqlx && config.MysqlxPort <= 0 {
+			p, release, err := ReservePort(config.PortLockDir)
 			if err != nil {
-				return nil, errors.Wrap(err, `could not find a temporary port to bind to`)
+				return nil, err
 			}
-			config.Port = p
+			config.MysqlxPort = p
+			guards = append(guards, release)
 		}
 	}
 
+	if config.Mysqlx && config.MysqlxSocket == "" {
+		config.MysqlxSocket = filepath.Join(config.TmpDir, "mysqlx.sock")
+	}
+
 	if config.PidFile == "" {
 		config.PidFile = filepath.Join(config.TmpDir, "mysqld.pid")
 	}
 
+	if config.SlowQueryLog && config.SlowQueryLogFile == "" {
+		config.SlowQueryLogFile = filepath.Join(config.TmpDir, "mysqld-slow.log")
+	}
+
 	if config.Mysqld == "" {
 		fullpath, err := lookMysqldPath()
 		if err != nil {
@@ -118,6 +176,11 @@ func NewMysqld(config *MysqldConfig) (*TestMysqld, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, `failed to execute 'mysqld --help --verbose'`)
 	}
+	mysqld.version = parseServerVersion(out)
+	if err := mysqld.checkListeners(mysqld.version); err != nil {
+		return nil, err
+	}
+
 	if !strings.Contains(string(out), "--initialize-insecure") && config.MysqlInstallDb == "" {
 		fullpath, err := exec.LookPath("mysql_install_db")
 		if err != nil {
@@ -126,12 +189,25 @@ func NewMysqld(config *MysqldConfig) (*TestMysqld, error) {
 		config.MysqlInstallDb = fullpath
 	}
 
-	mysqld := &TestMysqld{
-		config,
-		nil,
-		filepath.Join(config.BaseDir, "etc", "my.cnf"),
-		guards,
-		"",
+	mysqld.Guards = guards
+	mysqld.autoPort = autoPort
+	mysqld.releasePort = releasePort
+	mysqld.Guards = append(mysqld.Guards, func() {
+		if mysqld.releasePort != nil {
+			mysqld.releasePort()
+			mysqld.releasePort = nil
+		}
+	})
+
+	if config.SecureRoot || config.RootPassword != "" {
+		mysqld.RootPassword = config.RootPassword
+		if mysqld.RootPassword == "" {
+			pw, err := generatePassword()
+			if err != nil {
+				return nil, err
+			}
+			mysqld.RootPassword = pw
+		}
 	}
 
 	if config.AutoStart > 0 {
@@ -150,6 +226,7 @@ func NewMysqld(config *MysqldConfig) (*TestMysqld, error) {
 		}
 	}
 
+	succeeded = true
 	return mysqld, nil
 }
 
@@ -158,11 +235,51 @@ func (m *TestMysqld) BaseDir() string {
 	return m.Config.BaseDir
 }
 
-// Socket returns the unix socket location
+// Socket returns the unix socket location, or an empty string if
+// config.SkipSocket is set
 func (m *TestMysqld) Socket() string {
+	if !m.hasSocket() {
+		return ""
+	}
 	return m.Config.Socket
 }
 
+// Address returns the IPv4 TCP address (host:port), or an empty string
+// if config.SkipNetworking is set
+func (m *TestMysqld) Address() string {
+	if !m.hasIPv4() {
+		return ""
+	}
+	return net.JoinHostPort(m.Config.BindAddress, strconv.Itoa(m.Config.Port))
+}
+
+// Address6 returns the IPv6 TCP address ([host]:port), or an empty string
+// if config.BindAddress6 is not set
+func (m *TestMysqld) Address6() string {
+	if !m.hasIPv6() {
+		return ""
+	}
+	return net.JoinHostPort(m.Config.BindAddress6, strconv.Itoa(m.Config.Port6))
+}
+
+// XProtocolAddress returns the TCP address (host:port) of the X Protocol
+// endpoint, or an empty string if config.Mysqlx or networking is disabled
+func (m *TestMysqld) XProtocolAddress() string {
+	if !m.Config.Mysqlx || !m.hasTCP() {
+		return ""
+	}
+	return net.JoinHostPort(m.xBindAddress(), strconv.Itoa(m.Config.MysqlxPort))
+}
+
+// XProtocolSocket returns the unix socket location of the X Protocol
+// endpoint, or an empty string if config.Mysqlx is disabled
+func (m *TestMysqld) XProtocolSocket() string {
+	if !m.Config.Mysqlx {
+		return ""
+	}
+	return m.Config.MysqlxSocket
+}
+
 // AssertNotRunning returns nil if mysqld is not running
 func (m *TestMysqld) AssertNotRunning() error {
 	if pidfile := m.Config.PidFile; pidfile != "" {
@@ -191,38 +308,45 @@ func (m *TestMysqld) Setup() error {
 		}
 	}
 
+	if config.TLS {
+		if err := GenerateCertificates(m.certDir()); err != nil {
+			return errors.Wrap(err, `failed to generate certificates`)
+		}
+	}
+
+	if err := m.lookupMigrationCache(); err != nil {
+		return errors.Wrap(err, `failed to look up migration cache`)
+	}
+
+	// A cached data directory is complete, so copying it before setup
+	// also skips initializing a new one
+	if m.cachedDataDir != "" {
+		if err := m.cloneDataDir(m.cachedDataDir); err != nil {
+			return errors.Wrap(err, `failed to copy data from config.MigrationCacheDir`)
+		}
+	}
+
 	// When using `mysql_install_db`, copy the data before setup db for quick bootstrap.
 	// But `mysqld --initialize-insecure` doesn't work while the data dir exists,
 	// so don't copy here and do after setup db.
 	if config.MysqlInstallDb != "" && config.CopyDataFrom != "" {
-		if err := Dircopy(config.CopyDataFrom, config.DataDir); err != nil {
+		if err := m.cloneDataDir(config.CopyDataFrom); err != nil {
 			return errors.Wrap(err, `failed to copy data from config.CopyDataFrom`)
 		}
 	}
 
-	// XXX We should probably check for return values here...
-	var buf bytes.Buffer
-	buf.WriteString("[mysqld]\n")
-	fmt.Fprintf(&buf, "datadir=%s\n", config.DataDir)
-	fmt.Fprintf(&buf, "pid-file=%s\n", config.PidFile)
-	if config.SkipNetworking {
-		buf.WriteString("skip-networking\n")
-	} else {
-		fmt.Fprintf(&buf, "port=%d\n", config.Port)
+	if err := m.writeDefaultsFile(); err != nil {
+		return err
 	}
-	fmt.Fprintf(&buf, "socket=%s\n", config.Socket)
-	fmt.Fprintf(&buf, "tmpdir=%s\n", config.TmpDir)
 
-	file, err := os.OpenFile(m.DefaultsFile, os.O_CREATE|os.O_WRONLY, 0755)
-	if err != nil {
-		return errors.Wrap(err, `failed to create defaults file`)
+	if m.RootPassword != "" {
+		if err := m.writeInitFile(); err != nil {
+			return err
+		}
 	}
-	file.Write(buf.Bytes())
-	file.Sync()
-	file.Close()
 
 	vardir := filepath.Join(config.BaseDir, "var", "mysql")
-	_, err = os.Stat(vardir)
+	_, err := os.Stat(vardir)
 	if err != nil && os.IsNotExist(err) {
 		setupArgs := []string{fmt.Sprintf("--defaults-file=%s", m.DefaultsFile)}
 		setupCmd := config.MysqlInstallDb
@@ -262,6 +386,9 @@ func (m *TestMysqld) Setup() error {
 		} else {
 			setupCmd = config.Mysqld
 			setupArgs = append(setupArgs, "--initialize-insecure")
+			if m.RootPassword != "" {
+				setupArgs = append(setupArgs, fmt.Sprintf("--init-file=%s", m.initFile()))
+			}
 		}
 
 		cmd := exec.Command(setupCmd, setupArgs...)
@@ -273,33 +400,158 @@ func (m *TestMysqld) Setup() error {
 	}
 
 	if config.MysqlInstallDb == "" && config.CopyDataFrom != "" {
-		if err := Dircopy(config.CopyDataFrom, config.DataDir); err != nil {
-			return err
+		if err := m.cloneDataDir(config.CopyDataFrom); err != nil {
+			return errors.Wrap(err, `failed to copy data from config.CopyDataFrom`)
 		}
 	}
 
 	return nil
 }
 
-// Start starts the mysqld process
+// cloneDataDir copies from, config.CopyDataFrom or a cached data
+// directory, to config.DataDir
+func (m *TestMysqld) cloneDataDir(from string) error {
+	report, err := Clone(from, m.Config.DataDir, m.Config.CloneOptions)
+	if err != nil {
+		return err
+	}
+	m.cloneReport = report
+	return nil
+}
+
+// CloneReport describes how config.CopyDataFrom, or the cached data
+// directory from config.MigrationCacheDir, was copied during Setup,
+// or returns nil if neither was
+func (m *TestMysqld) CloneReport() *CloneReport {
+	return m.cloneReport
+}
+
+// writeDefaultsFile generates the my.cnf file passed to mysqld via
+// --defaults-file from the configuration
+func (m *TestMysqld) writeDefaultsFile() error {
+	config := m.Config
+
+	// XXX We should probably check for return values here...
+	var buf bytes.Buffer
+	buf.WriteString("[mysqld]\n")
+	fmt.Fprintf(&buf, "datadir=%s\n", config.DataDir)
+	fmt.Fprintf(&buf, "pid-file=%s\n", config.PidFile)
+	if !m.hasTCP() {
+		buf.WriteString("skip-networking\n")
+	} else {
+		fmt.Fprintf(&buf, "port=%d\n", m.tcpPort())
+		if addr := m.bindAddress(); addr != "" {
+			fmt.Fprintf(&buf, "bind-address=%s\n", addr)
+		}
+	}
+	fmt.Fprintf(&buf, "socket=%s\n", config.Socket)
+	fmt.Fprintf(&buf, "tmpdir=%s\n", config.TmpDir)
+	if config.Mysqlx {
+		buf.WriteString("mysqlx=ON\n")
+		fmt.Fprintf(&buf, "mysqlx_socket=%s\n", config.MysqlxSocket)
+		if m.hasTCP() {
+			fmt.Fprintf(&buf, "mysqlx_port=%d\n", config.MysqlxPort)
+			// mysqlx_bind_address is not available in 5.7
+			fmt.Fprintf(&buf, "loose-mysqlx_bind_address=%s\n", m.xBindAddress())
+		}
+	} else {
+		// loose- prefix, as the X Plugin is not available in older versions
+		buf.WriteString("loose-mysqlx=OFF\n")
+	}
+	if config.SlowQueryLog {
+		buf.WriteString("slow_query_log=1\n")
+		fmt.Fprintf(&buf, "slow_query_log_file=%s\n", config.SlowQueryLogFile)
+		buf.WriteString("long_query_time=0\n")
+		buf.WriteString("log_queries_not_using_indexes=1\n")
+	}
+	if config.TLS {
+		fmt.Fprintf(&buf, "ssl-ca=%s\n", filepath.Join(m.certDir(), CACertFile))
+		fmt.Fprintf(&buf, "ssl-cert=%s\n", filepath.Join(m.certDir(), ServerCertFile))
+		fmt.Fprintf(&buf, "ssl-key=%s\n", filepath.Join(m.certDir(), ServerKeyFile))
+		if config.RequireSecureTransport {
+			buf.WriteString("require_secure_transport=ON\n")
+		}
+	}
+	if config.DefaultAuthPlugin != "" {
+		buf.WriteString(defaultAuthPluginOption(m.version, config.DefaultAuthPlugin))
+	}
+	for _, plugin := range config.PluginLoad {
+		fmt.Fprintf(&buf, "plugin-load-add=%s\n", plugin)
+	}
+	if config.PerformanceSchema {
+		buf.WriteString("performance_schema=ON\n")
+		buf.WriteString("performance-schema-consumer-statements-digest=ON\n")
+	}
+
+	file, err := os.OpenFile(m.DefaultsFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return errors.Wrap(err, `failed to create defaults file`)
+	}
+	file.Write(buf.Bytes())
+	file.Sync()
+	file.Close()
+
+	return nil
+}
+
+// errAddressInUse is returned by start when mysqld fails to bind to its port
+var errAddressInUse = errors.New("error: mysqld failed to start (address already in use)")
+
+const maxStartAttempts = 5
+
+// Start starts the mysqld process. If the port was allocated
+// automatically and mysqld fails to bind to it because another
+// process grabbed it in the meantime, a new port is allocated,
+// recorded in Config.Port (or Config.Port6), and mysqld is started again
 func (m *TestMysqld) Start() error {
+	for i := 1; ; i++ {
+		err := m.start()
+		if err != errAddressInUse || !m.autoPort || i >= maxStartAttempts {
+			return err
+		}
+
+		if err := m.reallocatePort(); err != nil {
+			return errors.Wrap(err, `failed to allocate a new port`)
+		}
+	}
+}
+
+func (m *TestMysqld) start() error {
 	if err := m.AssertNotRunning(); err != nil {
 		return err
 	}
 
 	config := m.Config
+	if config.TLS {
+		if err := m.registerTLSConfig(); err != nil {
+			return err
+		}
+	}
+
 	logname := filepath.Join(config.TmpDir, "mysqld.log")
-	file, err := os.OpenFile(logname, os.O_CREATE|os.O_WRONLY, 0755)
+	file, err := os.OpenFile(logname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0755)
 	if err != nil {
 		return err
 	}
 	m.LogFile = logname
 
-	cmd := exec.Command(
-		config.Mysqld,
+	// Remember where the output of this run starts
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	logoffset := fi.Size()
+	m.logOffset = logoffset
+
+	args := []string{
 		fmt.Sprintf("--defaults-file=%s", m.DefaultsFile),
 		"--user=root",
-	)
+	}
+	if config.MysqlInstallDb != "" && m.RootPassword != "" {
+		args = append(args, fmt.Sprintf("--init-file=%s", m.initFile()))
+	}
+
+	cmd := exec.Command(config.Mysqld, args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
@@ -313,8 +565,14 @@ func (m *TestMysqld) Start() error {
 		return err
 	}
 
-	go io.Copy(file, stdoutpipe)
-	go io.Copy(file, stderrpipe)
+	var output io.Writer = file
+	if config.LogOutput != nil {
+		output = io.MultiWriter(file, config.LogOutput)
+	}
+	output = &syncWriter{w: output}
+
+	go io.Copy(output, stdoutpipe)
+	go io.Copy(output, stderrpipe)
 
 	if err := cmd.Start(); err != nil {
 		return errors.Wrap(err, "error: Failed to launch mysqld")
@@ -328,9 +586,7 @@ func (m *TestMysqld) Start() error {
 	for cmd.Process == nil {
 		select {
 		case <-checktimeout.C:
-			if proc := cmd.Process; proc != nil {
-				proc.Kill()
-			}
+			m.kill(cmd)
 			return errors.New("error: Failed to launch mysqld (timeout)")
 		case <-checktick.C:
 			// will force `for cmd.Process != nil` to be
@@ -348,11 +604,14 @@ func (m *TestMysqld) Start() error {
 	for {
 		select {
 		case <-conntimeout.C:
-			if proc := cmd.Process; proc != nil {
-				proc.Kill()
-			}
+			m.kill(cmd)
 			return errors.New("error: timeout reached before we could connect to database")
 		case <-conntick.C:
+			if m.hasTCP() && isAddressInUse(logname, logoffset, m.tcpPort()) {
+				m.kill(cmd)
+				return errAddressInUse
+			}
+
 			db, err := sql.Open("mysql", dsn)
 			if err != nil {
 				continue
@@ -361,26 +620,85 @@ func (m *TestMysqld) Start() error {
 			var id int
 			row := db.QueryRow("SELECT 1")
 			if err = row.Scan(&id); err != nil {
+				db.Close()
 				continue
 			}
 			m.Command = cmd
+			db.Close()
 
-			if config.CopyDataFrom == "" {
-				// Check if we have a database named "test". if not, create one
-				dsn := m.DSN(WithDbname("mysql"), WithUser("root"))
-				db, err := sql.Open("mysql", dsn)
-				if err != nil {
-					return errors.Wrap(err, `failed to connect to database`)
-				}
-
-				if _, err := db.Exec("CREATE DATABASE IF NOT EXISTS test"); err != nil {
-					return errors.Wrap(err, `failed to create database 'test'`)
-				}
+			if err := m.prepare(); err != nil {
+				m.kill(cmd)
+				return err
 			}
 			return nil
 		}
 	}
-	return errors.New("error: Could not connect to database. Server failed to start?")
+}
+
+// prepare brings a freshly started mysqld into the state described
+// by the config: accounts, migrations, fixtures and the Reset snapshot,
+// if config.Resettable is set
+func (m *TestMysqld) prepare() error {
+	config := m.Config
+
+	if config.CopyDataFrom == "" {
+		// Check if we have a database named "test". if not, create one
+		db, err := sql.Open("mysql", m.DSN(WithDbname("mysql"), WithUser("root")))
+		if err != nil {
+			return errors.Wrap(err, `failed to connect to mysqld`)
+		}
+		_, err = db.Exec("CREATE DATABASE IF NOT EXISTS test")
+		db.Close()
+		if err != nil {
+			return errors.Wrap(err, `failed to create database 'test'`)
+		}
+	}
+
+	if config.MysqlInstallDb != "" && m.RootPassword != "" {
+		if err := m.secureAccounts(); err != nil {
+			return errors.Wrap(err, `failed to secure root accounts`)
+		}
+	}
+
+	if err := m.provision(); err != nil {
+		return errors.Wrap(err, `failed to provision users`)
+	}
+
+	if config.Migrations != "" {
+		if err := m.Migrate(); err != nil {
+			return errors.Wrap(err, `failed to apply migrations`)
+		}
+
+		if m.migrationCache != "" {
+			if err := m.saveMigrationCache(); err != nil {
+				return errors.Wrap(err, `failed to cache the migrated data directory`)
+			}
+			// mysqld was shut down to copy its data directory
+			return m.start()
+		}
+	}
+
+	if config.Fixtures != "" {
+		if err := m.ImportFixtures(config.Fixtures); err != nil {
+			return errors.Wrap(err, `failed to load fixtures`)
+		}
+	}
+
+	m.snapshotState()
+	return nil
+}
+
+// kill stops the mysqld process started by start and waits for it to
+// exit, so that a failed start does not leave a server behind
+func (m *TestMysqld) kill(cmd *exec.Cmd) {
+	if proc := cmd.Process; proc != nil {
+		proc.Kill()
+		cmd.Wait()
+	}
+	os.Remove(m.Config.PidFile)
+	if m.Command == cmd {
+		m.Command = nil
+	}
 }
 
 // ReadLog reads the output log file specified by LogFile and returns its content
@@ -422,82 +740,96 @@ func (m *TestMysqld) ConnectString(port int) string {
 	return address
 }
 
-// Datasource is a utility function to format the DSN that can be passed
-// to mysqld driver.
-func Datasource(options ...DatasourceOption) string {
-	host := "localhost"
-	socket := ""
-	dbname := "test"
-	user := "root"
-	pass := ""
-	port := 3306
-	proto := "tcp"
-	q := url.Values{}
+// DSN creates a datasource name string that is appropriate for
+// connecting to the database instance started by TestMysqld.
+//
+// This method respects networking settings and host:port/socket
+// settings, and provide sane defaults for those parameters.
+// If you want to forcefully override them, you still can do so
+// by providing explicit DatasourceOption values
+func (m *TestMysqld) DSN(options ...DatasourceOption) string {
+	return Datasource(m.dsnOptions(options...)...)
+}
 
-	for _, o := range options {
-		name := o.Name()
-		switch name {
-		case "host":
-			host = o.Value().(string)
-		case "dbname":
-			dbname = o.Value().(string)
-		case "user":
-			user = o.Value().(string)
-		case "password":
-			pass = o.Value().(string)
-		case "proto":
-			proto = o.Value().(string)
-		case "socket":
-			socket = o.Value().(string)
-		case "port":
-			port = o.Value().(int)
-		case "parseTime":
-			q.Add(name, fmt.Sprintf("%t", o.Value().(bool)))
-		case "multiStatements":
-			q.Add(name, fmt.Sprintf("%t", o.Value().(bool)))
-		}
+// DSNE is like DSN, but returns an error if any of the options are
+// invalid, or if the protocol refers to a listener that is disabled
+func (m *TestMysqld) DSNE(options ...DatasourceOption) (string, error) {
+	list, err := m.dsnOptionsE(options...)
+	if err != nil {
+		return "", err
 	}
+	return DatasourceE(list...)
+}
 
-	var address string
-	switch proto {
-	case "unix":
-		address = fmt.Sprintf(`unix(%s)`, socket)
-	default: // Ah, ignore cases where proto != "unix" and != "tcp"
-		address = fmt.Sprintf(`tcp(%s:%d)`, host, port)
+// DriverConfig creates a mysql.Config that is appropriate for connecting
+// to the database instance started by TestMysqld. Options are handled
+// in the same way as DSN
+func (m *TestMysqld) DriverConfig(options ...DatasourceOption) *mysql.Config {
+	return DriverConfig(m.dsnOptions(options...)...)
+}
+
+// Connector creates a driver.Connector that can be passed to sql.OpenDB
+// to connect to the database instance started by TestMysqld. Options
+// are handled in the same way as DSN
+func (m *TestMysqld) Connector(options ...DatasourceOption) (driver.Connector, error) {
+	list, err := m.dsnOptionsE(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := DriverConfigE(list...)
+	if err != nil {
+		return nil, err
 	}
 
-	s := fmt.Sprintf(
-		"%s:%s@%s/%s",
-		user,
-		pass,
-		address,
-		dbname,
-	)
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to create connector`)
+	}
+	return connector, nil
+}
 
-	if qs := q.Encode(); qs != "" {
-		s = s + "?" + qs
+// dsnOptions fills in the defaults for DSN and DriverConfig. A protocol
+// whose listener is disabled is ignored, and the default protocol is
+// used instead
+func (m *TestMysqld) dsnOptions(options ...DatasourceOption) []DatasourceOption {
+	list, err := m.dsnOptionsE(options...)
+	if err == nil {
+		return list
+	}
+
+	list = make([]DatasourceOption, 0, len(options))
+	for _, o := range options {
+		if o.Name() != "proto" {
+			list = append(list, o)
+		}
 	}
-	return s
+	list, _ = m.dsnOptionsE(list...)
+	return list
 }
 
-// DSN creates a datasource name string that is appropriate for
-// connecting to the database instance started by TestMysqld.
-//
-// This method respects networking settings and host:port/socket
-// settings, and provide sane defaults for those parameters.
-// If you want to forcefully override them, you still can do so
-// by providing explicit DatasourceOption values
-func (m *TestMysqld) DSN(options ...DatasourceOption) string {
+// dsnOptionsE is like dsnOptions, but returns an error if the protocol
+// refers to a listener that is disabled, and no address was given
+func (m *TestMysqld) dsnOptionsE(options ...DatasourceOption) ([]DatasourceOption, error) {
 	var hasSocket bool
 	var hasHost bool
 	var hasPort bool
 	var hasProto bool
+	var hasTLS bool
+	var hasPassword bool
 	var proto string
+	user := "root"
 	for _, o := range options {
 		switch o.Name() {
+		case "user":
+			user, _ = o.Value().(string)
+		case "password":
+			hasPassword = true
+		case "tls":
+			hasTLS = true
 		case "proto":
 			hasProto = true
-			proto = o.Value().(string)
+			proto, _ = o.Value().(string)
 		case "socket":
 			hasSocket = true
 		case "host":
@@ -508,30 +840,56 @@ func (m *TestMysqld) DSN(options ...DatasourceOption) string {
 	}
 
 	if !hasProto {
-		if m.Config.SkipNetworking {
-			proto = "unix"
-		} else {
+		switch {
+		case m.hasIPv4():
 			proto = "tcp"
+		case m.hasSocket():
+			proto = "unix"
+		default:
+			proto = "tcp6"
 		}
 		options = append(options, WithProto(proto))
 	}
 
-	if proto == "unix" {
+	switch proto {
+	case "unix":
 		if !hasSocket {
+			if !m.hasSocket() {
+				return nil, errors.New(`unix socket is disabled (config.SkipSocket is set)`)
+			}
 			options = append(options, WithSocket(m.Config.Socket))
 		}
-	} else {
-
+	case "tcp6":
+		if !hasHost {
+			if !m.hasIPv6() {
+				return nil, errors.New(`IPv6 listener is disabled (config.BindAddress6 is empty)`)
+			}
+			options = append(options, WithHost(m.Config.BindAddress6))
+		}
+		if !hasPort {
+			options = append(options, WithPort(m.Config.Port6))
+		}
+	default:
 		if !hasHost {
+			if !m.hasIPv4() {
+				return nil, errors.New(`IPv4 listener is disabled (config.SkipNetworking is set)`)
+			}
 			options = append(options, WithHost(m.Config.BindAddress))
 		}
-
 		if !hasPort {
 			options = append(options, WithPort(m.Config.Port))
 		}
 	}
 
-	return Datasource(options...)
+	if user == "root" && !hasPassword && m.RootPassword != "" {
+		options = append(options, WithPassword(m.RootPassword))
+	}
+
+	if !hasTLS && m.TLSConfigName != "" {
+		options = append(options, WithTLS(m.TLSConfigName))
+	}
+
+	return options, nil
 }
 
 // Datasource is a DEPRECATED method to create a datasource string
@@ -555,57 +913,39 @@ func (m *TestMysqld) Datasource(dbname string, user string, pass string, port in
 
 // Stop explicitly stops the execution of mysqld
 func (m *TestMysqld) Stop() {
+	m.closeDBs()
+
 	if cmd := m.Command; cmd != nil {
 		if process := cmd.Process; process != nil {
 			process.Kill()
 		}
 	}
 
+	if m.TLSConfigName != "" {
+		mysql.DeregisterTLSConfig(m.TLSConfigName)
+		m.TLSConfigName = ""
+		m.tlsConfig = nil
+	}
+
+	if m.serverPubKeyName != "" {
+		mysql.DeregisterServerPubKey(m.serverPubKeyName)
+		m.serverPubKeyName = ""
+	}
+
 	// Run any guards that are registered
-	for _, g := range m.Guards {
+	m.guardsMu.Lock()
+	guards := append([]func(){}, m.Guards...)
+	m.guardsMu.Unlock()
+	for _, g := range guards {
 		g()
 	}
 }
 
-// Dircopy recursively copies directories and files
-func Dircopy(from string, to string) error {
-	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relpath, err := filepath.Rel(from, path)
-		if relpath == "." {
-			return nil
-		}
-
-		destpath := filepath.Join(to, relpath)
-
-		if info.IsDir() {
-			return os.Mkdir(destpath, info.Mode())
-		}
-
-		var src, dest *os.File
-		src, err = os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer src.Close()
-
-		flags := os.O_WRONLY | os.O_CREATE
-		dest, err = os.OpenFile(destpath, flags, info.Mode())
-		if err != nil {
-			return err
-		}
-		defer dest.Close()
-
-		_, err = io.Copy(dest, src)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+// addGuard registers g to be run by Stop
+func (m *TestMysqld) addGuard(g func()) {
+	m.guardsMu.Lock()
+	defer m.guardsMu.Unlock()
+	m.Guards = append(m.Guards, g)
 }
 
 var MysqlSearchPaths = []string{
@@ -1,14 +1,19 @@
 package mysqltest
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
 	"regexp"
-	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -51,15 +56,11 @@ func TestBasic(t *testing.T) {
 		return
 	}
 
-	// Got to wait for a bit till the log gets anything in it
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	buf, err := mysqld.ReadLog()
-	if err != nil {
-		t.Errorf("Failed to read log: %s", err)
-		return
-	}
-	if strings.Index(string(buf), "ready for connections") < 0 {
+	if _, err := mysqld.WaitForLog(ctx, "ready for connections"); err != nil {
+		buf, _ := mysqld.ReadLog()
 		t.Errorf("Could not find 'ready for connections' in log: %s", buf)
 		return
 	}
@@ -142,3 +143,223 @@ func TestDatasource(t *testing.T) {
 		t.Errorf("DSN %s should match %s", dsn, re)
 	}
 }
+
+func TestSecureRoot(t *testing.T) {
+	config := NewConfig()
+	config.SkipNetworking = false
+	config.SecureRoot = true
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	if !assert.NotEmpty(t, mysqld.RootPassword, "root password should be generated") {
+		return
+	}
+
+	db, err := sql.Open("mysql", mysqld.DSN())
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	if !assert.NoError(t, db.Ping(), "connecting with the root password should succeed") {
+		return
+	}
+
+	nopw, err := sql.Open("mysql", mysqld.DSN(WithPassword("")))
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer nopw.Close()
+
+	if !assert.Error(t, nopw.Ping(), "connecting without password should fail") {
+		return
+	}
+
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		tcp, err := sql.Open("mysql", mysqld.DSN(WithProto("tcp"), WithHost(host), WithPassword("")))
+		if !assert.NoError(t, err, "sql.Open should succeed") {
+			return
+		}
+		defer tcp.Close()
+
+		if !assert.Error(t, tcp.Ping(), "connecting over TCP to %s without password should fail", host) {
+			return
+		}
+	}
+}
+
+func TestDatasourceProto(t *testing.T) {
+	testcases := map[string][]DatasourceOption{
+		"root:@unix(/tmp/mysql.sock)/test":    {WithProto("unix"), WithSocket("/tmp/mysql.sock")},
+		"root:@tcp(127.0.0.1:13306)/test":     {WithProto("tcp"), WithHost("127.0.0.1"), WithPort(13306)},
+		"root:@tcp6([::1]:13306)/test":        {WithProto("tcp6"), WithHost("::1"), WithPort(13306)},
+		"root:@tcp(localhost:3306)/foo":       {WithDbname("foo")},
+		"app:s3cr3t@tcp(localhost:3306)/test": {WithUser("app"), WithPassword("s3cr3t")},
+	}
+
+	for expected, options := range testcases {
+		if !assert.Equal(t, expected, Datasource(options...), "dsn matches") {
+			return
+		}
+	}
+}
+
+func TestListeners(t *testing.T) {
+	config := NewConfig()
+	config.SkipNetworking = false
+	config.Mysqlx = true
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	if !assert.NotEqual(t, config.Port, config.MysqlxPort, "ports should be allocated independently") {
+		return
+	}
+	if !assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", config.Port), mysqld.Address(), "address matches") {
+		return
+	}
+	if !assert.Empty(t, mysqld.Address6(), "IPv6 address should be empty") {
+		return
+	}
+	if !assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", config.MysqlxPort), mysqld.XProtocolAddress(), "X Protocol address matches") {
+		return
+	}
+
+	conn, err := net.Dial("tcp", mysqld.XProtocolAddress())
+	if !assert.NoError(t, err, "connecting to the X Protocol port should succeed") {
+		return
+	}
+	conn.Close()
+
+	for _, proto := range []string{"unix", "tcp"} {
+		db, err := sql.Open("mysql", mysqld.DSN(WithProto(proto)))
+		if !assert.NoError(t, err, "sql.Open should succeed") {
+			return
+		}
+		defer db.Close()
+
+		if !assert.NoError(t, db.Ping(), "connecting via %s should succeed", proto) {
+			return
+		}
+	}
+}
+
+func TestDriverConfig(t *testing.T) {
+	cfg := DriverConfig(
+		WithProto("unix"),
+		WithSocket("/tmp/mysql.sock"),
+		WithCollation("utf8mb4_bin"),
+		WithCharset("utf8mb4"),
+		WithTimeout(5*time.Second),
+		WithReadTimeout(time.Second),
+		WithWriteTimeout(2*time.Second),
+		WithInterpolateParams(true),
+		WithClientFoundRows(true),
+		WithMaxAllowedPacket(1024),
+		WithConnectionAttributes("app:test"),
+		WithLoc(time.Local),
+	)
+
+	if !assert.Equal(t, "unix", cfg.Net, "net matches") {
+		return
+	}
+	if !assert.Equal(t, "/tmp/mysql.sock", cfg.Addr, "addr matches") {
+		return
+	}
+	if !assert.Equal(t, "root", cfg.User, "user matches") {
+		return
+	}
+	if !assert.Equal(t, "test", cfg.DBName, "dbname matches") {
+		return
+	}
+
+	dsn := Datasource(
+		WithProto("unix"),
+		WithSocket("/tmp/mysql.sock"),
+		WithCollation("utf8mb4_bin"),
+		WithCharset("utf8mb4"),
+		WithTimeout(5*time.Second),
+		WithReadTimeout(time.Second),
+		WithWriteTimeout(2*time.Second),
+		WithInterpolateParams(true),
+		WithClientFoundRows(true),
+		WithMaxAllowedPacket(1024),
+		WithConnectionAttributes("app:test"),
+	)
+
+	parsed, err := mysql.ParseDSN(dsn)
+	if !assert.NoError(t, err, "generated DSN should be parsed by the driver") {
+		return
+	}
+
+	cfg.Loc = parsed.Loc
+	if !assert.Equal(t, cfg.FormatDSN(), parsed.FormatDSN(), "parsed DSN matches") {
+		return
+	}
+}
+
+func TestGuards(t *testing.T) {
+	m := &TestMysqld{Config: NewConfig()}
+
+	var mu sync.Mutex
+	var calls int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.addGuard(func() {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+
+	m.Stop()
+	if !assert.Equal(t, 10, calls, "every guard is run") {
+		return
+	}
+}
+
+func TestNewMysqldFailure(t *testing.T) {
+	mysqld, err := exec.LookPath("false")
+	if err != nil {
+		t.Skip("false is not available")
+	}
+
+	lockdir, err := ioutil.TempDir("", "mysqltest-ports")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(lockdir)
+
+	config := NewConfig()
+	config.Mysqld = mysqld
+	config.Mysqlx = true
+	config.PortLockDir = lockdir
+
+	if _, err := NewMysqld(config); !assert.Error(t, err, "NewMysqld should fail") {
+		return
+	}
+
+	if _, err := os.Stat(config.BaseDir); !assert.True(t, os.IsNotExist(err), "temporary directory should be removed") {
+		return
+	}
+
+	locks, err := ioutil.ReadDir(lockdir)
+	if !assert.NoError(t, err, "ReadDir should succeed") {
+		return
+	}
+	if !assert.Empty(t, locks, "port locks should be released") {
+		return
+	}
+}
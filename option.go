@@ -1,5 +1,7 @@
 package mysqltest
 
+import "time"
+
 type optionWithValue struct {
 	name  string
 	value interface{}
@@ -13,7 +15,8 @@ func (o *optionWithValue) Value() interface{} {
 	return o.value
 }
 
-// WithProto specifies the connection protocol ("unix" or "tcp")
+// WithProto specifies the connection protocol ("unix", "tcp", or "tcp6").
+// "tcp6" connects to config.BindAddress6 and config.Port6
 func WithProto(s string) DatasourceOption {
 	return &optionWithValue{name: "proto", value: s}
 }
@@ -62,3 +65,98 @@ func WithParseTime(t bool) DatasourceOption {
 func WithMultiStatements(t bool) DatasourceOption {
 	return &optionWithValue{name: "multiStatements", value: t}
 }
+
+// WithTLS specifies the value of the `tls` parameter in the DSN. This can
+// be "true", "false", "skip-verify", "preferred", or the name of a
+// tls.Config registered with the mysql driver
+func WithTLS(s string) DatasourceOption {
+	return &optionWithValue{name: "tls", value: s}
+}
+
+// WithAllowNativePasswords specifies whether the mysql_native_password
+// authentication plugin is allowed
+func WithAllowNativePasswords(t bool) DatasourceOption {
+	return &optionWithValue{name: "allowNativePasswords", value: t}
+}
+
+// WithAllowCleartextPasswords specifies whether the cleartext client
+// side authentication plugin is allowed
+func WithAllowCleartextPasswords(t bool) DatasourceOption {
+	return &optionWithValue{name: "allowCleartextPasswords", value: t}
+}
+
+// WithServerPubKey specifies the name of the server's RSA public key
+// registered via mysql.RegisterServerPubKey, which is used for full
+// authentication with caching_sha2_password or sha256_password over
+// insecure connections. If not specified, the key is requested from
+// the server
+func WithServerPubKey(s string) DatasourceOption {
+	return &optionWithValue{name: "serverPubKey", value: s}
+}
+
+// WithAllowFallbackToPlaintext specifies whether the connection may
+// fall back to plaintext if the server does not support TLS
+func WithAllowFallbackToPlaintext(t bool) DatasourceOption {
+	return &optionWithValue{name: "allowFallbackToPlaintext", value: t}
+}
+
+// WithLoc specifies the location used for time.Time values when
+// parseTime is enabled
+func WithLoc(loc *time.Location) DatasourceOption {
+	return &optionWithValue{name: "loc", value: loc}
+}
+
+// WithCollation specifies the collation used for the connection
+func WithCollation(s string) DatasourceOption {
+	return &optionWithValue{name: "collation", value: s}
+}
+
+// WithCharset specifies the character set(s) used for the connection.
+// Multiple character sets may be separated by commas
+func WithCharset(s string) DatasourceOption {
+	return &optionWithValue{name: "charset", value: s}
+}
+
+// WithTimeout specifies the timeout for establishing connections
+func WithTimeout(d time.Duration) DatasourceOption {
+	return &optionWithValue{name: "timeout", value: d}
+}
+
+// WithReadTimeout specifies the I/O read timeout
+func WithReadTimeout(d time.Duration) DatasourceOption {
+	return &optionWithValue{name: "readTimeout", value: d}
+}
+
+// WithWriteTimeout specifies the I/O write timeout
+func WithWriteTimeout(d time.Duration) DatasourceOption {
+	return &optionWithValue{name: "writeTimeout", value: d}
+}
+
+// WithInterpolateParams specifies whether placeholders should be
+// interpolated on the client side instead of using prepared statements
+func WithInterpolateParams(t bool) DatasourceOption {
+	return &optionWithValue{name: "interpolateParams", value: t}
+}
+
+// WithClientFoundRows specifies whether UPDATE returns the number of
+// matching rows instead of the number of changed rows
+func WithClientFoundRows(t bool) DatasourceOption {
+	return &optionWithValue{name: "clientFoundRows", value: t}
+}
+
+// WithMaxAllowedPacket specifies the max packet size allowed, in bytes
+func WithMaxAllowedPacket(n int) DatasourceOption {
+	return &optionWithValue{name: "maxAllowedPacket", value: n}
+}
+
+// WithConnectionAttributes specifies the connection attributes sent to
+// the server, in the form "key1:value1,key2:value2"
+func WithConnectionAttributes(s string) DatasourceOption {
+	return &optionWithValue{name: "connectionAttributes", value: s}
+}
+
+// WithParam specifies an arbitrary parameter to be appended to the DSN.
+// The driver sends unknown parameters to the server as system variables
+func WithParam(key, value string) DatasourceOption {
+	return &optionWithValue{name: "param", value: dsnParam{key: key, value: value}}
+}
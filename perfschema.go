@@ -0,0 +1,136 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StatementStats represents a row in
+// performance_schema.events_statements_summary_by_digest
+type StatementStats struct {
+	Schema       string
+	Digest       string
+	DigestText   string
+	Count        int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+	RowsExamined int64
+	RowsSent     int64
+	RowsAffected int64
+	Errors       int64
+	Warnings     int64
+	NoIndexUsed  int64
+	FirstSeen    time.Time
+	LastSeen     time.Time
+}
+
+// UnusedIndex represents a row in sys.schema_unused_indexes
+type UnusedIndex struct {
+	Schema string
+	Table  string
+	Index  string
+}
+
+// performance_schema timers are in picoseconds
+func picoseconds(v int64) time.Duration {
+	return time.Duration(v / 1000)
+}
+
+// Statements issued by the helpers in this file use performance_schema
+// as their default database, so that they can be told apart from the
+// statements issued by the code being tested
+func (m *TestMysqld) openPerformanceSchema() (*sql.DB, error) {
+	db, err := sql.Open("mysql", m.DSN(WithDbname("performance_schema"), WithUser("root")))
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+	return db, nil
+}
+
+// ResetStatementStats clears the statistics collected in
+// performance_schema.events_statements_summary_by_digest.
+// Call this at the beginning of a test, and StatementStats at the end
+func (m *TestMysqld) ResetStatementStats() error {
+	db, err := m.openPerformanceSchema()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("TRUNCATE TABLE performance_schema.events_statements_summary_by_digest"); err != nil {
+		return errors.Wrap(err, `failed to truncate events_statements_summary_by_digest`)
+	}
+	return nil
+}
+
+// StatementStats returns per-digest statement statistics collected
+// since the server was started or ResetStatementStats was last called.
+// Statements whose default database is performance_schema are excluded.
+func (m *TestMysqld) StatementStats() ([]*StatementStats, error) {
+	db, err := m.openPerformanceSchema()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT SCHEMA_NAME, DIGEST, DIGEST_TEXT, COUNT_STAR, SUM_TIMER_WAIT, MAX_TIMER_WAIT, SUM_ROWS_EXAMINED, SUM_ROWS_SENT, SUM_ROWS_AFFECTED, SUM_ERRORS, SUM_WARNINGS, SUM_NO_INDEX_USED, FIRST_SEEN, LAST_SEEN FROM performance_schema.events_statements_summary_by_digest WHERE SCHEMA_NAME IS NULL OR SCHEMA_NAME <> 'performance_schema' ORDER BY SUM_TIMER_WAIT DESC`)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to query events_statements_summary_by_digest`)
+	}
+	defer rows.Close()
+
+	var list []*StatementStats
+	for rows.Next() {
+		var schema, digest, digestText sql.NullString
+		var totalLatency, maxLatency int64
+		var firstSeen, lastSeen string
+		var s StatementStats
+		if err := rows.Scan(&schema, &digest, &digestText, &s.Count, &totalLatency, &maxLatency, &s.RowsExamined, &s.RowsSent, &s.RowsAffected, &s.Errors, &s.Warnings, &s.NoIndexUsed, &firstSeen, &lastSeen); err != nil {
+			return nil, errors.Wrap(err, `failed to scan events_statements_summary_by_digest`)
+		}
+		s.Schema = schema.String
+		s.Digest = digest.String
+		s.DigestText = digestText.String
+		s.TotalLatency = picoseconds(totalLatency)
+		s.MaxLatency = picoseconds(maxLatency)
+		s.FirstSeen, _ = time.ParseInLocation("2006-01-02 15:04:05.999999", firstSeen, time.Local)
+		s.LastSeen, _ = time.ParseInLocation("2006-01-02 15:04:05.999999", lastSeen, time.Local)
+		list = append(list, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, `failed to read events_statements_summary_by_digest`)
+	}
+	return list, nil
+}
+
+// UnusedIndexes returns the indexes that have not been used since
+// the server was started, as reported by sys.schema_unused_indexes.
+// The sys schema is only available in MySQL 5.7 and later
+func (m *TestMysqld) UnusedIndexes() ([]*UnusedIndex, error) {
+	db, err := m.openPerformanceSchema()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT object_schema, object_name, index_name FROM sys.schema_unused_indexes ORDER BY object_schema, object_name, index_name`)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to query sys.schema_unused_indexes`)
+	}
+	defer rows.Close()
+
+	var list []*UnusedIndex
+	for rows.Next() {
+		var idx UnusedIndex
+		if err := rows.Scan(&idx.Schema, &idx.Table, &idx.Index); err != nil {
+			return nil, errors.Wrap(err, `failed to scan sys.schema_unused_indexes`)
+		}
+		list = append(list, &idx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, `failed to read sys.schema_unused_indexes`)
+	}
+	return list, nil
+}
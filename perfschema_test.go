@@ -0,0 +1,58 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementStats(t *testing.T) {
+	config := NewConfig()
+	config.PerformanceSchema = true
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	if !assert.NoError(t, mysqld.ResetStatementStats(), "ResetStatementStats should succeed") {
+		return
+	}
+
+	db, err := sql.Open("mysql", mysqld.DSN())
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		var v int
+		if !assert.NoError(t, db.QueryRow("SELECT 1 + ?", i).Scan(&v), "query should succeed") {
+			return
+		}
+	}
+
+	stats, err := mysqld.StatementStats()
+	if !assert.NoError(t, err, "StatementStats should succeed") {
+		return
+	}
+
+	var found *StatementStats
+	for _, s := range stats {
+		if strings.HasPrefix(s.DigestText, "SELECT ? + ?") {
+			found = s
+		}
+	}
+	if !assert.NotNil(t, found, "should find stats for SELECT") {
+		return
+	}
+	if !assert.Equal(t, int64(5), found.Count, "count matches") {
+		return
+	}
+	if !assert.Equal(t, "test", found.Schema, "schema matches") {
+		return
+	}
+}
@@ -0,0 +1,141 @@
+package mysqltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/lestrrat-go/tcputil"
+	"github.com/pkg/errors"
+)
+
+// DefaultPortLockDir is the directory used to coordinate port allocation
+// between processes, when config.PortLockDir is not specified
+var DefaultPortLockDir = filepath.Join(os.TempDir(), "mysqltest-ports")
+
+const maxPortAttempts = 100
+
+// ReservePort finds an unused TCP port, and reserves it by creating a
+// lock file in dir. Other processes (e.g. parallel `go test` binaries)
+// that reserve ports through the same directory will not receive the
+// same port until the returned function is called to release it.
+// Lock files left behind by processes that no longer exist are removed.
+func ReservePort(dir string) (int, func(), error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return 0, nil, errors.Wrap(err, `failed to create port lock directory`)
+	}
+
+	for i := 0; i < maxPortAttempts; i++ {
+		port, err := tcputil.EmptyPort()
+		if err != nil {
+			return 0, nil, errors.Wrap(err, `could not find a temporary port to bind to`)
+		}
+
+		lockfile := filepath.Join(dir, fmt.Sprintf("%d.lock", port))
+		ok, err := createLockFile(lockfile)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		return port, func() { os.Remove(lockfile) }, nil
+	}
+
+	return 0, nil, errors.New(`could not reserve a port (too many attempts)`)
+}
+
+// createLockFile atomically creates lockfile containing our pid. It
+// returns false if the file is held by another live process
+func createLockFile(lockfile string) (bool, error) {
+	for {
+		file, err := os.OpenFile(lockfile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d", os.Getpid())
+			file.Close()
+			return true, nil
+		}
+
+		if !os.IsExist(err) {
+			return false, errors.Wrapf(err, `failed to create lock file %s`, lockfile)
+		}
+
+		if !isStaleLockFile(lockfile) {
+			return false, nil
+		}
+
+		if err := os.Remove(lockfile); err != nil && !os.IsNotExist(err) {
+			return false, nil
+		}
+	}
+}
+
+func isStaleLockFile(lockfile string) bool {
+	buf, err := ioutil.ReadFile(lockfile)
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil || pid <= 0 {
+		// Possibly being written right now
+		return false
+	}
+
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// reallocatePort releases the automatically allocated port, reserves
+// a new one, and regenerates the defaults file
+func (m *TestMysqld) reallocatePort() error {
+	if m.releasePort != nil {
+		m.releasePort()
+		m.releasePort = nil
+	}
+
+	port, release, err := ReservePort(m.Config.PortLockDir)
+	if err != nil {
+		return err
+	}
+	m.setTCPPort(port)
+	m.releasePort = release
+
+	return m.writeDefaultsFile()
+}
+
+// bindFailure matches the messages mysqld logs when it cannot bind its
+// main port. The X Plugin reports "Address already in use" too, but
+// that does not prevent the server from starting
+var bindFailure = regexp.MustCompile(`(?i)(bind on tcp/ip port|do you already have another mysqld server running on port: (\d+))`)
+
+// isAddressInUse checks if mysqld logged that it failed to bind to
+// port, after the given offset in the log file
+func isAddressInUse(logname string, offset int64, port int) bool {
+	file, err := os.Open(logname)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, 0); err != nil {
+		return false
+	}
+
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return false
+	}
+
+	for _, m := range bindFailure.FindAllSubmatch(buf, -1) {
+		if len(m[2]) == 0 || string(m[2]) == strconv.Itoa(port) {
+			return true
+		}
+	}
+	return false
+}
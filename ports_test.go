@@ -0,0 +1,92 @@
+package mysqltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReservePort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-ports")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	port, release, err := ReservePort(dir)
+	if !assert.NoError(t, err, "ReservePort should succeed") {
+		return
+	}
+
+	lockfile := filepath.Join(dir, fmt.Sprintf("%d.lock", port))
+	if !assert.FileExists(t, lockfile, "lock file should exist") {
+		return
+	}
+
+	ok, err := createLockFile(lockfile)
+	if !assert.NoError(t, err, "createLockFile should succeed") {
+		return
+	}
+	if !assert.False(t, ok, "lock file held by a live process should not be taken") {
+		return
+	}
+
+	release()
+	if _, err := os.Stat(lockfile); !assert.True(t, os.IsNotExist(err), "lock file should be removed") {
+		return
+	}
+
+	// A lock file left behind by a dead process is taken over
+	if !assert.NoError(t, ioutil.WriteFile(lockfile, []byte("999999999"), 0644), "WriteFile should succeed") {
+		return
+	}
+	ok, err = createLockFile(lockfile)
+	if !assert.NoError(t, err, "createLockFile should succeed") {
+		return
+	}
+	if !assert.True(t, ok, "stale lock file should be taken over") {
+		return
+	}
+}
+
+func TestIsAddressInUse(t *testing.T) {
+	file, err := ioutil.TempFile("", "mysqltest-log")
+	if !assert.NoError(t, err, "TempFile should succeed") {
+		return
+	}
+	defer os.Remove(file.Name())
+
+	fmt.Fprintf(file, "2018-12-01T10:20:30.123456Z 0 [ERROR] [MY-010262] [Server] Can't start server: Bind on TCP/IP port: Address already in use\n")
+	fmt.Fprintf(file, "2018-12-01T10:20:30.123456Z 0 [ERROR] [MY-010257] [Server] Do you already have another mysqld server running on port: 3306 ?\n")
+	offset, _ := file.Seek(0, 1)
+	fmt.Fprintf(file, "2018-12-01T10:20:31.123456Z 0 [ERROR] [MY-011300] [Server] Plugin mysqlx reported: 'Setup of bind-address: '*' port: 33060 failed, `bind()` failed with error: Address already in use (98). Do you already have another mysqld server running with Mysqlx ?'\n")
+	fmt.Fprintf(file, "2018-12-01T10:20:31.123456Z 0 [System] [MY-010931] [Server] ready for connections.\n")
+	file.Close()
+
+	if !assert.True(t, isAddressInUse(file.Name(), 0, 3306), "should detect bind failure") {
+		return
+	}
+	if !assert.False(t, isAddressInUse(file.Name(), offset, 3306), "should ignore the X Plugin and output before offset") {
+		return
+	}
+
+	// MySQL 5.x only logs the port number
+	file, err = ioutil.TempFile("", "mysqltest-log")
+	if !assert.NoError(t, err, "TempFile should succeed") {
+		return
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, "181201 10:20:30 [ERROR] Do you already have another mysqld server running on port: 3307 ?\n")
+	file.Close()
+
+	if !assert.False(t, isAddressInUse(file.Name(), 0, 3306), "should ignore other ports") {
+		return
+	}
+	if !assert.True(t, isAddressInUse(file.Name(), 0, 3307), "should detect bind failure on the port") {
+		return
+	}
+}
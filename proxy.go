@@ -0,0 +1,230 @@
+package mysqltest
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Proxy is a TCP proxy that sits between the client and mysqld, and
+// can be used to inject network faults. Create one via TestMysqld.Proxy
+type Proxy struct {
+	mu        sync.Mutex
+	listener  net.Listener
+	network   string
+	address   string
+	mysqld    *TestMysqld
+	conns     map[*proxyConn]struct{}
+	latency   time.Duration
+	jitter    time.Duration
+	bandwidth int
+	blackhole bool
+	closed    bool
+}
+
+type proxyConn struct {
+	client net.Conn
+	server net.Conn
+}
+
+// serverAddr returns the network and address that proxies should
+// forward connections to
+func (m *TestMysqld) serverAddr() (string, string) {
+	switch {
+	case m.hasIPv4():
+		return "tcp", m.Address()
+	case m.hasSocket():
+		return "unix", m.Config.Socket
+	default:
+		return "tcp", m.Address6()
+	}
+}
+
+// Proxy starts a new TCP proxy listening on a random port on 127.0.0.1,
+// which forwards connections to the mysqld instance. The proxy is
+// closed when Stop is called
+func (m *TestMysqld) Proxy() (*Proxy, error) {
+	network, address := m.serverAddr()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to listen for proxy`)
+	}
+
+	p := &Proxy{
+		listener: l,
+		network:  network,
+		address:  address,
+		mysqld:   m,
+		conns:    make(map[*proxyConn]struct{}),
+	}
+	m.addGuard(func() { p.Close() })
+
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on
+func (p *Proxy) Addr() *net.TCPAddr {
+	return p.listener.Addr().(*net.TCPAddr)
+}
+
+// DSN creates a datasource name string that connects to mysqld
+// through the proxy. Options are handled as in TestMysqld.DSN
+func (p *Proxy) DSN(options ...DatasourceOption) string {
+	addr := p.Addr()
+	list := []DatasourceOption{
+		WithProto("tcp"),
+		WithHost(addr.IP.String()),
+		WithPort(addr.Port),
+	}
+	return p.mysqld.DSN(append(list, options...)...)
+}
+
+// SetLatency delays every chunk of data passing through the proxy, in
+// either direction, by latency plus a random duration up to jitter
+func (p *Proxy) SetLatency(latency, jitter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency = latency
+	p.jitter = jitter
+}
+
+// SetBandwidth limits the throughput of each direction of each
+// connection to bytesPerSecond. Zero removes the limit
+func (p *Proxy) SetBandwidth(bytesPerSecond int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bandwidth = bytesPerSecond
+}
+
+// SetBlackhole makes the proxy silently discard all data in both
+// directions, while keeping connections open. New connections are
+// still accepted, but never complete the handshake
+func (p *Proxy) SetBlackhole(b bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blackhole = b
+}
+
+// DropConnections closes all active connections
+func (p *Proxy) DropConnections() {
+	p.closeConns(false)
+}
+
+// ResetConnections closes all active connections, sending a TCP RST
+// to the client instead of a graceful FIN
+func (p *Proxy) ResetConnections() {
+	p.closeConns(true)
+}
+
+// Close stops accepting new connections and closes all active ones
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	p.closeConns(false)
+	return err
+}
+
+func (p *Proxy) closeConns(reset bool) {
+	p.mu.Lock()
+	conns := p.conns
+	p.conns = make(map[*proxyConn]struct{})
+	p.mu.Unlock()
+
+	for c := range conns {
+		if tcp, ok := c.client.(*net.TCPConn); ok && reset {
+			tcp.SetLinger(0)
+		}
+		c.client.Close()
+		c.server.Close()
+	}
+}
+
+func (p *Proxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server, err := net.Dial(p.network, p.address)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		c := &proxyConn{client: client, server: server}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			client.Close()
+			server.Close()
+			return
+		}
+		p.conns[c] = struct{}{}
+		p.mu.Unlock()
+
+		go p.pipe(c, client, server)
+		go p.pipe(c, server, client)
+	}
+}
+
+// delay computes how long a chunk of n bytes should be held back,
+// and whether it should be discarded
+func (p *Proxy) delay(n int) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.blackhole {
+		return 0, true
+	}
+
+	d := p.latency
+	if p.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(p.jitter)))
+	}
+	if p.bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(p.bandwidth)
+	}
+	return d, false
+}
+
+func (p *Proxy) pipe(c *proxyConn, src, dst net.Conn) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, c)
+		p.mu.Unlock()
+		c.client.Close()
+		c.server.Close()
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			d, discard := p.delay(n)
+			if d > 0 {
+				time.Sleep(d)
+			}
+			if !discard {
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mysqltest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Use a simple echo server in place of mysqld, so that the proxy
+// can be tested without starting a server
+func startEchoServer(t *testing.T) (*TestMysqld, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	m := &TestMysqld{
+		Config: &MysqldConfig{
+			BindAddress: addr.IP.String(),
+			Port:        addr.Port,
+		},
+	}
+	return m, func() { l.Close() }
+}
+
+func TestProxy(t *testing.T) {
+	m, cleanup := startEchoServer(t)
+	defer cleanup()
+
+	p, err := m.Proxy()
+	if !assert.NoError(t, err, "Proxy should succeed") {
+		return
+	}
+	defer p.Close()
+
+	if !assert.Regexp(t, `@tcp\(127\.0\.0\.1:\d+\)/`, p.DSN(), "DSN points to the proxy") {
+		return
+	}
+
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if !assert.NoError(t, err, "Dial should succeed") {
+		return
+	}
+	defer conn.Close()
+
+	echo := func() (string, error) {
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return "", err
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	t.Run("Passthrough", func(t *testing.T) {
+		s, err := echo()
+		if !assert.NoError(t, err, "echo should succeed") {
+			return
+		}
+		assert.Equal(t, "ping", s, "echo matches")
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		p.SetLatency(100*time.Millisecond, 0)
+		defer p.SetLatency(0, 0)
+
+		start := time.Now()
+		if _, err := echo(); !assert.NoError(t, err, "echo should succeed") {
+			return
+		}
+		// Latency is applied in both directions
+		assert.True(t, time.Since(start) >= 200*time.Millisecond, "echo should be delayed")
+	})
+
+	t.Run("Blackhole", func(t *testing.T) {
+		p.SetBlackhole(true)
+		defer p.SetBlackhole(false)
+
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		defer conn.SetReadDeadline(time.Time{})
+
+		_, err := echo()
+		if !assert.Error(t, err, "echo should time out") {
+			return
+		}
+		nerr, ok := err.(net.Error)
+		assert.True(t, ok && nerr.Timeout(), "error should be a timeout")
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		p.DropConnections()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 1)
+		_, err := conn.Read(buf)
+		assert.Equal(t, io.EOF, err, "connection should be closed")
+	})
+}
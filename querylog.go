@@ -0,0 +1,240 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Query represents a single statement recorded in the general query log
+type Query struct {
+	Time         time.Time
+	ConnectionID int64
+	User         string
+	Host         string
+	Database     string
+	Command      string // "Query", "Prepare", or "Execute"
+	Statement    string
+}
+
+// Queries is a list of statements captured by CaptureQueries
+type Queries []*Query
+
+// CaptureQueries enables the general query log, runs fn, and returns
+// the statements that were executed by other connections while fn was
+// running. The general log is written to the mysql.general_log table,
+// and the previous logging settings are restored before returning.
+//
+// The Database field is resolved from the schema each connection was
+// using when the capture started, and is then updated on "USE db" and
+// COM_INIT_DB. Schema changes made in other ways, such as inside
+// stored programs, are not tracked.
+//
+// Captures must not be run concurrently against the same server.
+func (m *TestMysqld) CaptureQueries(fn func()) (Queries, error) {
+	db, err := sql.Open("mysql", m.DSN(WithDbname("mysql"), WithUser("root")))
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+	defer db.Close()
+
+	// All control statements must go through the same connection so
+	// that we can exclude them from the result
+	db.SetMaxOpenConns(1)
+
+	var self int64
+	if err := db.QueryRow("SELECT CONNECTION_ID()").Scan(&self); err != nil {
+		return nil, errors.Wrap(err, `failed to fetch connection id`)
+	}
+
+	var logOutput string
+	var generalLog int
+	if err := db.QueryRow("SELECT @@global.log_output, @@global.general_log").Scan(&logOutput, &generalLog); err != nil {
+		return nil, errors.Wrap(err, `failed to fetch general log settings`)
+	}
+
+	// Connections opened before the capture, such as idle connections
+	// in a pool, have no "Connect" record in the log, so we need to
+	// know which schema they are using now
+	databases, err := currentDatabases(db)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		db.Exec("SET GLOBAL log_output = ?", logOutput)
+		db.Exec("SET GLOBAL general_log = ?", generalLog)
+	}()
+
+	for _, stmt := range []string{
+		"SET GLOBAL general_log = 0",
+		"TRUNCATE TABLE mysql.general_log",
+		"SET GLOBAL log_output = 'TABLE'",
+		"SET GLOBAL general_log = 1",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, errors.Wrapf(err, `failed to execute '%s'`, stmt)
+		}
+	}
+
+	func() {
+		defer db.Exec("SET GLOBAL general_log = 0")
+		fn()
+	}()
+
+	rows, err := db.Query(`SELECT event_time, user_host, thread_id, command_type, argument FROM mysql.general_log WHERE thread_id <> ? ORDER BY event_time`, self)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to read mysql.general_log`)
+	}
+	defer rows.Close()
+
+	var queries Queries
+	for rows.Next() {
+		var eventTime, userHost, command, argument string
+		var thread int64
+		if err := rows.Scan(&eventTime, &userHost, &thread, &command, &argument); err != nil {
+			return nil, errors.Wrap(err, `failed to scan mysql.general_log`)
+		}
+
+		switch command {
+		case "Connect":
+			// "root@localhost on test using Socket"
+			if i := strings.Index(argument, " on "); i > -1 {
+				db := argument[i+4:]
+				if j := strings.Index(db, " using "); j > -1 {
+					db = db[:j]
+				}
+				databases[thread] = strings.TrimSpace(db)
+			}
+			continue
+		case "Init DB":
+			databases[thread] = argument
+			continue
+		case "Query":
+			if m := useStatement.FindStringSubmatch(argument); m != nil {
+				databases[thread] = unquoteIdentifier(m[1])
+			}
+		case "Prepare", "Execute":
+		default:
+			continue
+		}
+
+		q := &Query{
+			ConnectionID: thread,
+			Database:     databases[thread],
+			Command:      command,
+			Statement:    argument,
+		}
+		q.Time, _ = time.ParseInLocation("2006-01-02 15:04:05.999999", eventTime, time.Local)
+		q.User, q.Host = parseUserHost(userHost)
+		queries = append(queries, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, `failed to read mysql.general_log`)
+	}
+
+	return queries, nil
+}
+
+var useStatement = regexp.MustCompile("(?i)^\\s*use\\s+(`(?:[^`]|``)+`|[^\\s;]+)\\s*;?\\s*$")
+
+// currentDatabases returns the schema used by each connection
+func currentDatabases(db *sql.DB) (map[int64]string, error) {
+	rows, err := db.Query("SELECT id, db FROM information_schema.processlist")
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to list connections`)
+	}
+	defer rows.Close()
+
+	databases := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name sql.NullString
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, errors.Wrap(err, `failed to list connections`)
+		}
+		databases[id] = name.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, `failed to list connections`)
+	}
+	return databases, nil
+}
+
+// unquoteIdentifier removes the backquotes around an identifier
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && s[0] == '`' && s[len(s)-1] == '`' {
+		return strings.Replace(s[1:len(s)-1], "``", "`", -1)
+	}
+	return s
+}
+
+// parseUserHost parses the user_host column in mysql.general_log,
+// which looks like "root[root] @ localhost []" or "root[root] @  [127.0.0.1]"
+func parseUserHost(s string) (string, string) {
+	var user, host string
+	i := strings.Index(s, " @ ")
+	if i < 0 {
+		return s, ""
+	}
+
+	user = s[:i]
+	if j := strings.IndexByte(user, '['); j > -1 {
+		user = user[:j]
+	}
+
+	host = strings.TrimSpace(s[i+3:])
+	if j := strings.IndexByte(host, '['); j > -1 {
+		addr := strings.TrimSuffix(host[j+1:], "]")
+		host = strings.TrimSpace(host[:j])
+		if host == "" {
+			host = addr
+		}
+	}
+	return user, host
+}
+
+// Filter returns the list of queries for which fn returns true
+func (l Queries) Filter(fn func(*Query) bool) Queries {
+	var result Queries
+	for _, q := range l {
+		if fn(q) {
+			result = append(result, q)
+		}
+	}
+	return result
+}
+
+// Match returns the list of queries whose statement matches the
+// regular expression pattern. It panics if pattern is not a valid
+// regular expression
+func (l Queries) Match(pattern string) Queries {
+	re := regexp.MustCompile(pattern)
+	return l.Filter(func(q *Query) bool {
+		return re.MatchString(q.Statement)
+	})
+}
+
+// Count returns the number of queries whose statement matches the
+// regular expression pattern
+func (l Queries) Count(pattern string) int {
+	return len(l.Match(pattern))
+}
+
+// Contains returns true if at least one query matches the regular
+// expression pattern
+func (l Queries) Contains(pattern string) bool {
+	return l.Count(pattern) > 0
+}
+
+// Statements returns the list of statements as strings
+func (l Queries) Statements() []string {
+	list := make([]string, len(l))
+	for i, q := range l {
+		list[i] = q.Statement
+	}
+	return list
+}
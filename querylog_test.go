@@ -0,0 +1,102 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserHost(t *testing.T) {
+	testcases := map[string][2]string{
+		"root[root] @ localhost []":   {"root", "localhost"},
+		"app[app] @  [127.0.0.1]":     {"app", "127.0.0.1"},
+		"[root] @ localhost [::1]":    {"", "localhost"},
+		"event_scheduler[event_sche]": {"event_scheduler[event_sche]", ""},
+	}
+
+	for input, expected := range testcases {
+		user, host := parseUserHost(input)
+		if !assert.Equal(t, expected[0], user, "user matches for %s", input) {
+			return
+		}
+		if !assert.Equal(t, expected[1], host, "host matches for %s", input) {
+			return
+		}
+	}
+}
+
+func TestUseStatement(t *testing.T) {
+	testcases := map[string]string{
+		"USE test":           "test",
+		"use `my db`;":       "my db",
+		"  Use `a``b` ":      "a`b",
+		"USE test; SELECT 1": "",
+		"SELECT 1":           "",
+	}
+
+	for input, expected := range testcases {
+		var db string
+		if m := useStatement.FindStringSubmatch(input); m != nil {
+			db = unquoteIdentifier(m[1])
+		}
+		if !assert.Equal(t, expected, db, "database matches for %s", input) {
+			return
+		}
+	}
+}
+
+func TestCaptureQueries(t *testing.T) {
+	mysqld, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := sql.Open("mysql", mysqld.DSN())
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	queries, err := mysqld.CaptureQueries(func() {
+		for i := 0; i < 3; i++ {
+			var v int
+			db.QueryRow("SELECT 1 + 1").Scan(&v)
+		}
+	})
+	if !assert.NoError(t, err, "CaptureQueries should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, 3, queries.Count(`^SELECT 1 \+ 1$`), "should capture 3 queries") {
+		return
+	}
+
+	if !assert.Equal(t, "test", queries.Match(`^SELECT`)[0].Database, "database matches") {
+		return
+	}
+
+	t.Run("Connections opened before the capture", func(t *testing.T) {
+		db.SetMaxOpenConns(1)
+		if !assert.NoError(t, db.Ping(), "Ping should succeed") {
+			return
+		}
+
+		queries, err := mysqld.CaptureQueries(func() {
+			db.Exec("SELECT 2")
+			db.Exec("USE mysql")
+			db.Exec("SELECT 3")
+		})
+		if !assert.NoError(t, err, "CaptureQueries should succeed") {
+			return
+		}
+
+		if !assert.Equal(t, "test", queries.Match(`^SELECT 2$`)[0].Database, "database is resolved from the processlist") {
+			return
+		}
+		if !assert.Equal(t, "mysql", queries.Match(`^SELECT 3$`)[0].Database, "USE is tracked") {
+			return
+		}
+	})
+}
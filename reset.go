@@ -0,0 +1,342 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// ResetOptions controls the behavior of Reset
+type ResetOptions struct {
+	// Truncate is the list of schemas whose tables are truncated, and
+	// refilled with the rows they contained right after Start. If nil,
+	// all non-system schemas that existed right after Start are reset.
+	// The schema_migrations table is never truncated
+	Truncate []string
+}
+
+// systemSchemas are never dropped nor truncated by Reset
+var systemSchemas = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+// MySQL error numbers that Reset tolerates
+const (
+	errUnknownThread      = 1094 // the connection went away before KILL
+	errUnknownSystemVar   = 1193 // the variable is listed but cannot be set
+	errIncorrectGlobalVar = 1228 // the variable is session-only
+	errWrongValueForVar   = 1231 // the value read back is not accepted
+	errWrongTypeForVar    = 1232 // e.g. an empty string for a numeric variable
+	errReadOnlyGlobalVar  = 1238 // the variable cannot be changed at runtime
+	errReadOnlySessionVar = 1621 // the variable is read-only
+)
+
+var numericValue = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// snapshotState records the schemas, the rows in their tables and the
+// global variables right after Start (including migrations and
+// fixtures), so that Reset can return to that state. It only runs when
+// config.Resettable is set, and a failure is reported by Reset rather
+// than failing Start
+func (m *TestMysqld) snapshotState() {
+	m.initialSchemas = nil
+	m.initialData = nil
+	m.initialGlobals = nil
+	m.snapshotErr = nil
+	if !m.Config.Resettable {
+		return
+	}
+
+	if err := m.readSnapshot(); err != nil {
+		m.initialSchemas = nil
+		m.initialData = nil
+		m.initialGlobals = nil
+		m.snapshotErr = err
+	}
+}
+
+func (m *TestMysqld) readSnapshot() error {
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	schemas, err := queryStrings(db, "SHOW DATABASES")
+	if err != nil {
+		return errors.Wrap(err, `failed to list schemas`)
+	}
+	m.initialSchemas = make(map[string]bool)
+	m.initialData = make(map[string]map[string]*fixture)
+	for _, s := range schemas {
+		m.initialSchemas[s] = true
+		if systemSchemas[s] {
+			continue
+		}
+
+		data, err := m.snapshotData(s)
+		if err != nil {
+			return err
+		}
+		m.initialData[s] = data
+	}
+
+	globals, err := globalVariables(db)
+	if err != nil {
+		return err
+	}
+	m.initialGlobals = globals
+	return nil
+}
+
+// snapshotData reads the rows of the non-empty tables in schema, so
+// that seed data inserted by migrations or fixtures survives Reset
+func (m *TestMysqld) snapshotData(schema string) (map[string]*fixture, error) {
+	// Values are read as text, so DATETIME and friends must not be
+	// converted to time.Time
+	db, err := sql.Open("mysql", m.DSN(WithDbname(schema), WithUser("root"), WithParseTime(false)))
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+	defer db.Close()
+
+	tables, err := queryStrings(db, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name")
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to list tables in %s`, schema)
+	}
+
+	data := make(map[string]*fixture)
+	for _, table := range tables {
+		if table == "schema_migrations" {
+			continue
+		}
+		f, err := readFixture(db, table)
+		if err != nil {
+			return nil, errors.Wrapf(err, `failed to snapshot %s`, schema)
+		}
+		if len(f.Rows) > 0 {
+			data[table] = f
+		}
+	}
+	return data, nil
+}
+
+func globalVariables(db Queryer) (map[string]sql.NullString, error) {
+	rows, err := db.Query("SHOW GLOBAL VARIABLES")
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to read global variables`)
+	}
+	defer rows.Close()
+
+	globals := make(map[string]sql.NullString)
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, errors.Wrap(err, `failed to read global variables`)
+		}
+		globals[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, `failed to read global variables`)
+	}
+	return globals, nil
+}
+
+func isMySQLError(err error, numbers ...uint16) bool {
+	merr, ok := errors.Cause(err).(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	for _, n := range numbers {
+		if merr.Number == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset returns the server to the state it was in right after Start,
+// without restarting it. It kills all other client connections, which
+// discards their session state (session variables, temporary tables,
+// user variables, locks), drops the schemas created since Start,
+// truncates tables (which also resets auto-increment counters) and
+// reinserts the rows they contained right after Start, such as data
+// from migrations and config.Fixtures, and restores global variables
+// changed via SET GLOBAL. opts may be nil.
+//
+// The state is recorded by Start only when config.Resettable is set.
+// Handles returned by DB stay usable, but their connections are
+// re-established
+func (m *TestMysqld) Reset(opts *ResetOptions) error {
+	if !m.Config.Resettable {
+		return errors.New(`config.Resettable is not set`)
+	}
+	if m.snapshotErr != nil {
+		return errors.Wrap(m.snapshotErr, `failed to record the state after Start`)
+	}
+	if m.initialSchemas == nil {
+		return errors.New(`mysqld has not been started`)
+	}
+	if opts == nil {
+		opts = &ResetOptions{}
+	}
+
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, `failed to connect to database`)
+	}
+	defer conn.Close()
+
+	if err := killConnections(ctx, conn); err != nil {
+		return err
+	}
+
+	// Idle connections in the pools were killed too
+	m.dbMu.Lock()
+	for _, h := range m.dbs {
+		h.SetMaxIdleConns(0)
+		h.SetMaxIdleConns(dbMaxIdleConns)
+	}
+	m.dbMu.Unlock()
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return errors.Wrap(err, `failed to disable foreign key checks`)
+	}
+
+	schemas, err := queryStrings(db, "SHOW DATABASES")
+	if err != nil {
+		return errors.Wrap(err, `failed to list schemas`)
+	}
+	for _, s := range schemas {
+		if systemSchemas[s] || m.initialSchemas[s] {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, "DROP DATABASE "+quoteIdentifier(s)); err != nil {
+			return errors.Wrapf(err, `failed to drop schema %s`, s)
+		}
+	}
+
+	truncate := opts.Truncate
+	if truncate == nil {
+		for s := range m.initialSchemas {
+			if !systemSchemas[s] {
+				truncate = append(truncate, s)
+			}
+		}
+		sort.Strings(truncate)
+	}
+	for _, s := range truncate {
+		tables, err := queryStrings(db, "SELECT table_name FROM information_schema.tables WHERE table_schema = ? AND table_type = 'BASE TABLE' ORDER BY table_name", s)
+		if err != nil {
+			return errors.Wrapf(err, `failed to list tables in %s`, s)
+		}
+		if len(tables) == 0 {
+			continue
+		}
+
+		// loadFixture works on the current database
+		if _, err := conn.ExecContext(ctx, "USE "+quoteIdentifier(s)); err != nil {
+			return errors.Wrapf(err, `failed to use schema %s`, s)
+		}
+		for _, table := range tables {
+			if table == "schema_migrations" {
+				continue
+			}
+			if f, ok := m.initialData[s][table]; ok {
+				if err := loadFixture(ctx, conn, table, f); err != nil {
+					return errors.Wrapf(err, `failed to restore table %s.%s`, s, table)
+				}
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, "TRUNCATE TABLE "+quoteIdentifier(s)+"."+quoteIdentifier(table)); err != nil {
+				return errors.Wrapf(err, `failed to truncate table %s.%s`, s, table)
+			}
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1"); err != nil {
+		return errors.Wrap(err, `failed to enable foreign key checks`)
+	}
+
+	return m.restoreGlobals(ctx, conn, db)
+}
+
+// killConnections kills all client connections except conn
+func killConnections(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, "SELECT id FROM information_schema.processlist WHERE id <> CONNECTION_ID() AND user NOT IN ('system user', 'event_scheduler') AND command NOT IN ('Daemon', 'Binlog Dump', 'Binlog Dump GTID')")
+	if err != nil {
+		return errors.Wrap(err, `failed to list connections`)
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return errors.Wrap(err, `failed to list connections`)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, `failed to list connections`)
+	}
+
+	for _, id := range ids {
+		if _, err := conn.ExecContext(ctx, "KILL "+strconv.FormatInt(id, 10)); err != nil && !isMySQLError(err, errUnknownThread) {
+			return errors.Wrapf(err, `failed to kill connection %d`, id)
+		}
+	}
+	return nil
+}
+
+// restoreGlobals sets global variables that were changed since Start
+// back to their original values. Variables that cannot be set to the
+// value read back from the server are left as they are
+func (m *TestMysqld) restoreGlobals(ctx context.Context, conn *sql.Conn, db Queryer) error {
+	current, err := globalVariables(db)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range m.initialGlobals {
+		if v, ok := current[name]; !ok || v == value {
+			continue
+		}
+
+		// Integer variables reject string literals
+		var literal string
+		switch {
+		case !value.Valid:
+			literal = "NULL"
+		case numericValue.MatchString(value.String):
+			literal = value.String
+		default:
+			literal = quoteString(value.String)
+		}
+
+		if _, err := conn.ExecContext(ctx, "SET GLOBAL "+name+" = "+literal); err != nil {
+			if isMySQLError(err, errUnknownSystemVar, errIncorrectGlobalVar, errWrongValueForVar, errWrongTypeForVar, errReadOnlyGlobalVar, errReadOnlySessionVar) {
+				continue
+			}
+			return errors.Wrapf(err, `failed to restore global variable %s`, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package mysqltest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReset(t *testing.T) {
+	dir := writeMigrations(t, map[string]string{
+		"0001_seed.up.sql": "CREATE TABLE colors (id INT NOT NULL PRIMARY KEY, name VARCHAR(16));\nINSERT INTO colors VALUES (1, 'red'), (2, NULL);",
+	})
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Migrations = dir
+	config.Resettable = true
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE orders (id INT NOT NULL AUTO_INCREMENT PRIMARY KEY, item VARCHAR(64) NOT NULL)",
+		"INSERT INTO orders (item) VALUES ('apple'), ('banana')",
+		"DELETE FROM colors WHERE id = 1",
+		"INSERT INTO colors VALUES (3, 'blue')",
+		"CREATE DATABASE scratch",
+		"SET GLOBAL max_connections = 42",
+	} {
+		if _, err := db.Exec(stmt); !assert.NoError(t, err, "Exec should succeed") {
+			return
+		}
+	}
+
+	if !assert.NoError(t, mysqld.Reset(nil), "Reset should succeed") {
+		return
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count); !assert.NoError(t, err, "orders should still exist") {
+		return
+	}
+	if !assert.Equal(t, 0, count, "orders should be truncated") {
+		return
+	}
+
+	if _, err := db.Exec("INSERT INTO orders (item) VALUES ('cherry')"); !assert.NoError(t, err, "Exec should succeed") {
+		return
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM orders").Scan(&id); !assert.NoError(t, err, "QueryRow should succeed") {
+		return
+	}
+	if !assert.Equal(t, 1, id, "auto increment is reset") {
+		return
+	}
+
+	var colors []string
+	rows, err := db.Query("SELECT id, COALESCE(name, 'NULL') FROM colors ORDER BY id")
+	if !assert.NoError(t, err, "Query should succeed") {
+		return
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		if !assert.NoError(t, rows.Scan(&id, &name), "Scan should succeed") {
+			rows.Close()
+			return
+		}
+		colors = append(colors, fmt.Sprintf("%d:%s", id, name))
+	}
+	rows.Close()
+	if !assert.Equal(t, []string{"1:red", "2:NULL"}, colors, "seed data is restored") {
+		return
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = 'scratch'").Scan(&count); !assert.NoError(t, err, "QueryRow should succeed") {
+		return
+	}
+	if !assert.Equal(t, 0, count, "scratch should be dropped") {
+		return
+	}
+
+	var maxConnections int
+	if err := db.QueryRow("SELECT @@GLOBAL.max_connections").Scan(&maxConnections); !assert.NoError(t, err, "QueryRow should succeed") {
+		return
+	}
+	if !assert.NotEqual(t, 42, maxConnections, "max_connections is restored") {
+		return
+	}
+}
+
+func TestResetWithoutSnapshot(t *testing.T) {
+	// mysqld is not running, so any attempt to touch the database fails
+	m := &TestMysqld{Config: NewConfig()}
+	if !assert.EqualError(t, m.Reset(nil), "config.Resettable is not set", "Reset requires config.Resettable") {
+		return
+	}
+
+	m.Config.Resettable = true
+	m.snapshotErr = fmt.Errorf("table is gone")
+	if !assert.EqualError(t, m.Reset(nil), "failed to record the state after Start: table is gone", "Reset reports the snapshot error") {
+		return
+	}
+}
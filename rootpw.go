@@ -0,0 +1,82 @@
+package mysqltest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+func generatePassword() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, `failed to generate random password`)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (m *TestMysqld) initFile() string {
+	return filepath.Join(m.Config.BaseDir, "etc", "init.sql")
+}
+
+// writeInitFile creates the file passed to mysqld via --init-file,
+// which sets the root password.
+//
+// When bootstrapping with `mysqld --initialize-insecure` the file is
+// executed once during initialization, and ALTER USER is available.
+// Servers that require `mysql_install_db` (MySQL 5.6 and earlier,
+// MariaDB) do not support --init-file during bootstrap, so the file is
+// executed every time mysqld starts instead, using SET PASSWORD which
+// is supported by all of those versions. mysql_install_db also creates
+// other root accounts, which are handled by secureAccounts
+func (m *TestMysqld) writeInitFile() error {
+	// quoteString does not escape backslashes
+	stmt := fmt.Sprintf("SET SESSION sql_mode = %s;\n", noBackslashEscapes)
+	if m.Config.MysqlInstallDb == "" {
+		stmt += fmt.Sprintf("ALTER USER 'root'@'localhost' IDENTIFIED BY %s;\n", quoteString(m.RootPassword))
+	} else {
+		stmt += fmt.Sprintf("SET PASSWORD FOR 'root'@'localhost' = PASSWORD(%s);\n", quoteString(m.RootPassword))
+	}
+
+	if err := ioutil.WriteFile(m.initFile(), []byte(stmt), 0600); err != nil {
+		return errors.Wrap(err, `failed to write init file`)
+	}
+	return nil
+}
+
+// secureAccounts sets the root password for the accounts created by
+// mysql_install_db besides root@localhost, such as root@127.0.0.1,
+// root@::1 and root@<hostname>, and drops the anonymous accounts, so
+// that nobody can log in over TCP without a password
+func (m *TestMysqld) secureAccounts() error {
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	hosts, err := queryStrings(db, "SELECT host FROM mysql.user WHERE user = 'root' AND host <> 'localhost'")
+	if err != nil {
+		return errors.Wrap(err, `failed to list root accounts`)
+	}
+	for _, host := range hosts {
+		stmt := fmt.Sprintf("SET PASSWORD FOR 'root'@%s = PASSWORD(%s)", quoteString(host), quoteString(m.RootPassword))
+		if _, err := db.Exec(stmt); err != nil {
+			return errors.Wrapf(err, `failed to set password for root@%s`, host)
+		}
+	}
+
+	hosts, err = queryStrings(db, "SELECT host FROM mysql.user WHERE user = ''")
+	if err != nil {
+		return errors.Wrap(err, `failed to list anonymous accounts`)
+	}
+	for _, host := range hosts {
+		if _, err := db.Exec("DROP USER ''@" + quoteString(host)); err != nil {
+			return errors.Wrapf(err, `failed to drop anonymous account @%s`, host)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package mysqltest
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// SlowQuery represents a single record in the slow query log
+type SlowQuery struct {
+	Time         time.Time
+	User         string
+	Host         string
+	ConnectionID int64
+	QueryTime    time.Duration
+	LockTime     time.Duration
+	RowsSent     int64
+	RowsExamined int64
+	Database     string
+	Statement    string
+}
+
+// FullTableScan is reported by FindFullTableScans for each table
+// that a statement reads without using an index
+type FullTableScan struct {
+	Query *SlowQuery
+	Table string
+}
+
+var slowLogField = regexp.MustCompile(`(\w+):\s+(\S+)`)
+
+// ParseSlowLog parses the content of a slow query log
+func ParseSlowLog(src io.Reader) ([]*SlowQuery, error) {
+	var list []*SlowQuery
+	var current *SlowQuery
+	var stmt []string
+
+	// The slow log only emits "use db;" when the database changes
+	// for a connection, so we need to remember it ourselves
+	databases := make(map[int64]string)
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if len(stmt) > 0 {
+			current.Statement = strings.TrimSuffix(strings.TrimSpace(strings.Join(stmt, "\n")), ";")
+		}
+		if current.Database == "" {
+			current.Database = databases[current.ConnectionID]
+		} else {
+			databases[current.ConnectionID] = current.Database
+		}
+		if current.Statement != "" {
+			list = append(list, current)
+		}
+		current = nil
+		stmt = nil
+	}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# Time: "):
+			flush()
+			current = &SlowQuery{Time: parseSlowLogTime(strings.TrimSpace(line[8:]))}
+		case strings.HasPrefix(line, "# User@Host: "):
+			// 5.1 does not emit "# Time:" for every query
+			if current == nil || current.User != "" || len(stmt) > 0 {
+				var t time.Time
+				if current != nil {
+					t = current.Time
+				}
+				flush()
+				current = &SlowQuery{Time: t}
+			}
+			userHost := line[13:]
+			if i := strings.Index(userHost, "Id:"); i > -1 {
+				current.ConnectionID, _ = strconv.ParseInt(strings.TrimSpace(userHost[i+3:]), 10, 64)
+				userHost = userHost[:i]
+			}
+			current.User, current.Host = parseUserHost(strings.TrimSpace(userHost))
+		case strings.HasPrefix(line, "# Query_time: "):
+			if current == nil {
+				current = &SlowQuery{}
+			}
+			for _, m := range slowLogField.FindAllStringSubmatch(line, -1) {
+				switch m[1] {
+				case "Query_time":
+					current.QueryTime = parseSlowLogDuration(m[2])
+				case "Lock_time":
+					current.LockTime = parseSlowLogDuration(m[2])
+				case "Rows_sent":
+					current.RowsSent, _ = strconv.ParseInt(m[2], 10, 64)
+				case "Rows_examined":
+					current.RowsExamined, _ = strconv.ParseInt(m[2], 10, 64)
+				}
+			}
+		case current == nil:
+			// Header emitted by mysqld when the log is opened
+		case strings.HasPrefix(line, "# administrator command: "):
+			stmt = append(stmt, line[2:])
+		case strings.HasPrefix(line, "# "):
+			// Other header lines, such as "# Schema: " in some variants
+		case len(stmt) == 0 && strings.HasPrefix(strings.ToLower(line), "use "):
+			current.Database = strings.Trim(strings.TrimSuffix(strings.TrimSpace(line[4:]), ";"), "`")
+		case len(stmt) == 0 && strings.HasPrefix(line, "SET timestamp="):
+			if current.Time.IsZero() {
+				v := strings.TrimSuffix(line[14:], ";")
+				if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+					current.Time = time.Unix(ts, 0)
+				}
+			}
+		default:
+			stmt = append(stmt, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, `failed to read slow query log`)
+	}
+	flush()
+
+	return list, nil
+}
+
+func parseSlowLogTime(s string) time.Time {
+	// 5.7 and later: 2018-12-01T00:00:00.123456Z
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
+	}
+
+	// 5.6 and earlier: 181201  0:00:00
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return time.Time{}
+	}
+	if len(fields[1]) == 7 {
+		fields[1] = "0" + fields[1]
+	}
+	t, _ := time.ParseInLocation("060102 15:04:05", fields[0]+" "+fields[1], time.Local)
+	return t
+}
+
+func parseSlowLogDuration(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second))
+}
+
+// SlowQueries reads and parses the slow query log. config.SlowQueryLog
+// must be enabled for this to work
+func (m *TestMysqld) SlowQueries() ([]*SlowQuery, error) {
+	if !m.Config.SlowQueryLog {
+		return nil, errors.New(`slow query log is not enabled`)
+	}
+
+	file, err := os.Open(m.Config.SlowQueryLogFile)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to open slow query log`)
+	}
+	defer file.Close()
+
+	return ParseSlowLog(file)
+}
+
+var explainable = regexp.MustCompile(`(?is)^(?:SELECT|UPDATE|DELETE|INSERT\s.*\bSELECT\b|REPLACE\s.*\bSELECT\b)`)
+
+// stripLeadingComments removes whitespace and comments from the start
+// of stmt, so that the statement type can be checked
+func stripLeadingComments(stmt string) string {
+	for {
+		stmt = strings.TrimLeft(stmt, " \t\r\n")
+		switch {
+		case strings.HasPrefix(stmt, "/*"):
+			i := strings.Index(stmt[2:], "*/")
+			if i < 0 {
+				return ""
+			}
+			stmt = stmt[i+4:]
+		case strings.HasPrefix(stmt, "#"), strings.HasPrefix(stmt, "-- "), strings.HasPrefix(stmt, "--\t"), stmt == "--":
+			i := strings.IndexByte(stmt, '\n')
+			if i < 0 {
+				return ""
+			}
+			stmt = stmt[i+1:]
+		default:
+			return stmt
+		}
+	}
+}
+
+// FindFullTableScans runs EXPLAIN against each SELECT, UPDATE, DELETE,
+// INSERT ... SELECT and REPLACE ... SELECT statement in queries, and
+// reports every table that is accessed via a full table scan.
+// Statements may span several lines and start with comments.
+// Queries against the system schemas are ignored, and so are statements
+// that the server can no longer explain, such as those against a table
+// that was dropped since, or a temporary table of another session.
+func (m *TestMysqld) FindFullTableScans(queries []*SlowQuery) ([]*FullTableScan, error) {
+	db, err := sql.Open("mysql", m.DSN(WithDbname("mysql"), WithUser("root")))
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+	defer conn.Close()
+
+	var list []*FullTableScan
+	for _, q := range queries {
+		switch strings.ToLower(q.Database) {
+		case "", "mysql", "information_schema", "performance_schema", "sys":
+			continue
+		}
+		stmt := stripLeadingComments(q.Statement)
+		if !explainable.MatchString(stmt) {
+			continue
+		}
+
+		if _, err := conn.ExecContext(ctx, "USE `"+strings.Replace(q.Database, "`", "``", -1)+"`"); err != nil {
+			if isServerError(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, `failed to switch to database %s`, q.Database)
+		}
+
+		tables, err := explainFullScans(ctx, conn, stmt)
+		if err != nil {
+			if isServerError(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, `failed to explain '%s'`, q.Statement)
+		}
+		for _, table := range tables {
+			list = append(list, &FullTableScan{Query: q, Table: table})
+		}
+	}
+	return list, nil
+}
+
+// isServerError returns true if err was reported by the server for a
+// single statement, as opposed to a broken connection
+func isServerError(err error) bool {
+	_, ok := errors.Cause(err).(*mysql.MySQLError)
+	return ok
+}
+
+func explainFullScans(ctx context.Context, conn *sql.Conn, stmt string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "EXPLAIN "+stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// The set of columns returned by EXPLAIN differs between versions
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	tableIdx, typeIdx := -1, -1
+	for i, name := range columns {
+		switch strings.ToLower(name) {
+		case "table":
+			tableIdx = i
+		case "type":
+			typeIdx = i
+		}
+	}
+	if tableIdx < 0 || typeIdx < 0 {
+		return nil, errors.New(`unexpected EXPLAIN output`)
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var tables []string
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if string(values[typeIdx]) == "ALL" {
+			tables = append(tables, string(values[tableIdx]))
+		}
+	}
+	return tables, rows.Err()
+}
@@ -0,0 +1,166 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const slowLog57 = `/usr/sbin/mysqld, Version: 5.7.24 (MySQL Community Server (GPL)). started with:
+Tcp port: 0  Unix socket: /tmp/mysqltest/tmp/mysql.sock
+Time                 Id Command    Argument
+# Time: 2018-12-01T10:20:30.123456Z
+# User@Host: root[root] @ localhost []  Id:     3
+# Query_time: 0.001500  Lock_time: 0.000100 Rows_sent: 1  Rows_examined: 42
+use test;
+SET timestamp=1543659630;
+SELECT *
+  FROM hello
+ WHERE str = 'ciao';
+# Time: 2018-12-01T10:20:31.000000Z
+# User@Host: root[root] @ localhost []  Id:     3
+# Query_time: 0.000010  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1543659631;
+# administrator command: Quit;
+`
+
+const slowLog55 = `# Time: 181201 10:20:30
+# User@Host: app[app] @  [127.0.0.1]
+# Query_time: 2.000000  Lock_time: 0.000000 Rows_sent: 3  Rows_examined: 3
+use foo;
+SET timestamp=1543659630;
+SELECT 1;
+# User@Host: app[app] @  [127.0.0.1]
+# Query_time: 0.500000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1543659630;
+DELETE FROM bar;
+`
+
+func TestParseSlowLog(t *testing.T) {
+	t.Run("5.7", func(t *testing.T) {
+		list, err := ParseSlowLog(strings.NewReader(slowLog57))
+		if !assert.NoError(t, err, "ParseSlowLog should succeed") {
+			return
+		}
+		if !assert.Len(t, list, 2, "should parse 2 records") {
+			return
+		}
+
+		expected := &SlowQuery{
+			Time:         time.Date(2018, 12, 1, 10, 20, 30, 123456000, time.UTC),
+			User:         "root",
+			Host:         "localhost",
+			ConnectionID: 3,
+			QueryTime:    1500 * time.Microsecond,
+			LockTime:     100 * time.Microsecond,
+			RowsSent:     1,
+			RowsExamined: 42,
+			Database:     "test",
+			Statement:    "SELECT *\n  FROM hello\n WHERE str = 'ciao'",
+		}
+		if !assert.Equal(t, expected, list[0], "first record matches") {
+			return
+		}
+		if !assert.Equal(t, "administrator command: Quit", list[1].Statement, "second record matches") {
+			return
+		}
+		if !assert.Equal(t, "test", list[1].Database, "database is carried over") {
+			return
+		}
+	})
+	t.Run("5.5", func(t *testing.T) {
+		list, err := ParseSlowLog(strings.NewReader(slowLog55))
+		if !assert.NoError(t, err, "ParseSlowLog should succeed") {
+			return
+		}
+		if !assert.Len(t, list, 2, "should parse 2 records") {
+			return
+		}
+		if !assert.Equal(t, "127.0.0.1", list[0].Host, "host matches") {
+			return
+		}
+		if !assert.Equal(t, 2*time.Second, list[0].QueryTime, "query time matches") {
+			return
+		}
+		if !assert.Equal(t, "DELETE FROM bar", list[1].Statement, "statement matches") {
+			return
+		}
+		if !assert.Equal(t, "foo", list[1].Database, "database is carried over") {
+			return
+		}
+		if !assert.Equal(t, time.Unix(1543659630, 0), list[1].Time, "time is taken from SET timestamp") {
+			return
+		}
+	})
+}
+
+func TestExplainable(t *testing.T) {
+	testcases := map[string]bool{
+		"SELECT 1":                                        true,
+		"select *\n  from hello":                          true,
+		"/* app:users */ SELECT * FROM users":             true,
+		"-- fetch\n# more\nDELETE FROM hello":             true,
+		"INSERT INTO archive\nSELECT * FROM hello":        true,
+		"REPLACE INTO archive (id)\n  SELECT id FROM foo": true,
+		"INSERT INTO hello VALUES (1)":                    false,
+		"/* SELECT */ SHOW TABLES":                        false,
+		"/* unterminated SELECT":                          false,
+	}
+
+	for stmt, expected := range testcases {
+		if !assert.Equal(t, expected, explainable.MatchString(stripLeadingComments(stmt)), "result matches for %q", stmt) {
+			return
+		}
+	}
+}
+
+func TestFindFullTableScans(t *testing.T) {
+	config := NewConfig()
+	config.SlowQueryLog = true
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := sql.Open("mysql", mysqld.DSN())
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	for _, stmt := range []string{
+		"CREATE TABLE scan_me (id INT NOT NULL PRIMARY KEY, name VARCHAR(32))",
+		"INSERT INTO scan_me VALUES (1, 'one'), (2, 'two'), (3, 'three')",
+		"SELECT * FROM scan_me WHERE id = 1",
+		"SELECT * FROM scan_me WHERE name = 'two'",
+		"CREATE TABLE dropped (id INT NOT NULL PRIMARY KEY, name VARCHAR(32))",
+		"SELECT * FROM dropped WHERE name = 'gone'",
+		"DROP TABLE dropped",
+	} {
+		if _, err := db.Exec(stmt); !assert.NoError(t, err, "%s should succeed", stmt) {
+			return
+		}
+	}
+
+	queries, err := mysqld.SlowQueries()
+	if !assert.NoError(t, err, "SlowQueries should succeed") {
+		return
+	}
+
+	scans, err := mysqld.FindFullTableScans(queries)
+	if !assert.NoError(t, err, "FindFullTableScans should succeed") {
+		return
+	}
+
+	if !assert.Len(t, scans, 1, "should find 1 full table scan") {
+		return
+	}
+	if !assert.Equal(t, "scan_me", scans[0].Table, "table matches") {
+		return
+	}
+}
@@ -0,0 +1,187 @@
+package mysqltest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// File names of the certificates generated under BaseDir/etc
+const (
+	CACertFile     = "ca.pem"
+	ServerCertFile = "server-cert.pem"
+	ServerKeyFile  = "server-key.pem"
+	ClientCertFile = "client-cert.pem"
+	ClientKeyFile  = "client-key.pem"
+)
+
+var tlsConfigID int64
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	der  []byte
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func generateCertificate(template *x509.Certificate, parent *certificate) (*certificate, error) {
+	// RSA keys are used because older servers built against yaSSL
+	// do not support anything else
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to generate private key`)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to generate serial number`)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(10 * 365 * 24 * time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to create certificate`)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to parse certificate`)
+	}
+
+	return &certificate{cert: cert, key: key, der: der}, nil
+}
+
+func (c *certificate) write(certfile, keyfile string) error {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := ioutil.WriteFile(certfile, certPEM, 0644); err != nil {
+		return errors.Wrapf(err, `failed to write %s`, certfile)
+	}
+
+	if keyfile == "" {
+		return nil
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(c.key)})
+	if err := ioutil.WriteFile(keyfile, keyPEM, 0600); err != nil {
+		return errors.Wrapf(err, `failed to write %s`, keyfile)
+	}
+	return nil
+}
+
+// GenerateCertificates creates a throwaway CA, and server and client
+// certificates signed by it in dir. The server certificate is valid
+// for localhost, 127.0.0.1 and ::1
+func GenerateCertificates(dir string) error {
+	ca, err := generateCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "mysqltest CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil)
+	if err != nil {
+		return errors.Wrap(err, `failed to generate CA certificate`)
+	}
+
+	server, err := generateCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}, ca)
+	if err != nil {
+		return errors.Wrap(err, `failed to generate server certificate`)
+	}
+
+	client, err := generateCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mysqltest client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	if err != nil {
+		return errors.Wrap(err, `failed to generate client certificate`)
+	}
+
+	if err := ca.write(filepath.Join(dir, CACertFile), ""); err != nil {
+		return err
+	}
+	if err := server.write(filepath.Join(dir, ServerCertFile), filepath.Join(dir, ServerKeyFile)); err != nil {
+		return err
+	}
+	return client.write(filepath.Join(dir, ClientCertFile), filepath.Join(dir, ClientKeyFile))
+}
+
+func (m *TestMysqld) certDir() string {
+	return filepath.Join(m.Config.BaseDir, "etc")
+}
+
+// registerTLSConfig loads the certificates generated by Setup, and
+// registers a matching tls.Config with the mysql driver
+func (m *TestMysqld) registerTLSConfig() error {
+	if m.TLSConfigName != "" {
+		return nil
+	}
+
+	dir := m.certDir()
+	caPEM, err := ioutil.ReadFile(filepath.Join(dir, CACertFile))
+	if err != nil {
+		return errors.Wrap(err, `failed to read CA certificate`)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return errors.New(`failed to parse CA certificate`)
+	}
+
+	client, err := tls.LoadX509KeyPair(filepath.Join(dir, ClientCertFile), filepath.Join(dir, ClientKeyFile))
+	if err != nil {
+		return errors.Wrap(err, `failed to load client certificate`)
+	}
+
+	config := &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{client},
+		// Required when connecting through the unix socket, as
+		// the driver cannot guess the server name from the address
+		ServerName: "localhost",
+	}
+
+	name := fmt.Sprintf("mysqltest-%d", atomic.AddInt64(&tlsConfigID, 1))
+	if err := mysql.RegisterTLSConfig(name, config); err != nil {
+		return errors.Wrap(err, `failed to register TLS config`)
+	}
+
+	m.TLSConfigName = name
+	m.tlsConfig = config
+	return nil
+}
+
+// TLSConfig returns the tls.Config that can be used to connect to the
+// server when config.TLS is enabled. It is registered with the mysql
+// driver under the name stored in TLSConfigName
+func (m *TestMysqld) TLSConfig() *tls.Config {
+	return m.tlsConfig
+}
@@ -0,0 +1,89 @@
+package mysqltest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqltest-certs")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	if !assert.NoError(t, GenerateCertificates(dir), "GenerateCertificates should succeed") {
+		return
+	}
+
+	caPEM, err := ioutil.ReadFile(filepath.Join(dir, CACertFile))
+	if !assert.NoError(t, err, "reading CA should succeed") {
+		return
+	}
+	pool := x509.NewCertPool()
+	if !assert.True(t, pool.AppendCertsFromPEM(caPEM), "CA should be parsed") {
+		return
+	}
+
+	for _, pair := range [][3]string{
+		{ServerCertFile, ServerKeyFile, "localhost"},
+		{ClientCertFile, ClientKeyFile, ""},
+	} {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, pair[0]), filepath.Join(dir, pair[1]))
+		if !assert.NoError(t, err, "LoadX509KeyPair should succeed for %s", pair[0]) {
+			return
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if !assert.NoError(t, err, "ParseCertificate should succeed for %s", pair[0]) {
+			return
+		}
+
+		_, err = leaf.Verify(x509.VerifyOptions{
+			DNSName:   pair[2],
+			Roots:     pool,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if !assert.NoError(t, err, "%s should be signed by CA", pair[0]) {
+			return
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	config := NewConfig()
+	config.SkipNetworking = false
+	config.TLS = true
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	dsn := mysqld.DSN()
+	if !assert.Regexp(t, "tls="+mysqld.TLSConfigName, dsn, "dsn should contain tls parameter") {
+		return
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	var name, cipher string
+	if !assert.NoError(t, db.QueryRow("SHOW STATUS LIKE 'Ssl_cipher'").Scan(&name, &cipher), "query should succeed") {
+		return
+	}
+	if !assert.NotEmpty(t, cipher, "connection should be encrypted") {
+		return
+	}
+}
@@ -0,0 +1,253 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Tx begins a transaction on the handle returned by DB, and returns it
+// along with a function that rolls it back. Use it for tests that only
+// need their changes to be isolated from other tests
+func (m *TestMysqld) Tx(options ...DatasourceOption) (*sql.Tx, func() error, error) {
+	db, err := m.DB(options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, `failed to begin transaction`)
+	}
+	return tx, tx.Rollback, nil
+}
+
+// TxDB returns a *sql.DB that uses a single connection, on which a
+// transaction is started when it is first used. Transactions started
+// through the handle are emulated with savepoints, so code under test
+// can begin, commit and roll back as usual. Calling the returned
+// function rolls back everything and closes the handle. Stop does the
+// same for handles that are still open.
+//
+// If the connection is lost, its transaction is gone, and the next
+// statement runs on a new connection in a new transaction
+//
+// Options are handled in the same way as DB. Note that statements that
+// cause an implicit commit, such as DDL, end the outer transaction
+func (m *TestMysqld) TxDB(options ...DatasourceOption) (*sql.DB, func() error, error) {
+	base, err := m.Connector(dbOptions(options)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connector := &txConnector{base: base}
+	db := sql.OpenDB(connector)
+
+	// database/sql must never use the connection concurrently, nor
+	// close it behind our back
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		connector.close()
+		return nil, nil, errors.Wrap(err, `failed to connect to database`)
+	}
+
+	cleanup := func() error {
+		err := connector.close()
+		db.Close()
+		return err
+	}
+	m.addGuard(func() { cleanup() })
+	return db, cleanup, nil
+}
+
+// txConnector hands out the same connection every time, with a
+// transaction open on it
+type txConnector struct {
+	base driver.Connector
+
+	mu   sync.Mutex
+	conn *txConn
+}
+
+func (c *txConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		if c.conn.IsValid() {
+			return c.conn, nil
+		}
+		// database/sql discarded the connection, which took the
+		// transaction with it
+		c.conn.Conn.Close()
+		c.conn = nil
+	}
+
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &txConn{Conn: conn}
+	if err := tc.exec(ctx, "START TRANSACTION"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.conn = tc
+	return tc, nil
+}
+
+func (c *txConnector) Driver() driver.Driver {
+	return c.base.Driver()
+}
+
+// close rolls back the transaction and closes the real connection
+func (c *txConnector) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	conn := c.conn
+	c.conn = nil
+
+	err := conn.exec(context.Background(), "ROLLBACK")
+	if cerr := conn.Conn.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Wrap(err, `failed to roll back transaction`)
+}
+
+// txConn wraps a driver connection so that transactions become
+// savepoints, and Close leaves the connection open
+type txConn struct {
+	driver.Conn
+	savepoints int
+	bad        bool
+}
+
+// check remembers that the connection is broken, so that it is not
+// handed out again
+func (c *txConn) check(err error) error {
+	if err == driver.ErrBadConn {
+		c.bad = true
+	}
+	return err
+}
+
+func (c *txConn) exec(ctx context.Context, query string) error {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return errors.New(`driver does not support ExecerContext`)
+	}
+	_, err := execer.ExecContext(ctx, query, nil)
+	return c.check(err)
+}
+
+// IsValid implements driver.Validator, so that database/sql discards
+// the connection once it is broken
+func (c *txConn) IsValid() bool {
+	if c.bad {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// ResetSession implements driver.SessionResetter. The session is not
+// reset, since that would end the transaction, but the driver still
+// gets to report a broken connection
+func (c *txConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return c.check(r.ResetSession(ctx))
+	}
+	return nil
+}
+
+func (c *txConn) Close() error {
+	return nil
+}
+
+func (c *txConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx creates a savepoint. The isolation level and read-only
+// options cannot be applied to a savepoint, and are ignored
+func (c *txConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.savepoints++
+	name := "mysqltest_" + strconv.Itoa(c.savepoints)
+	if err := c.exec(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &txSavepoint{conn: c, name: name}, nil
+}
+
+func (c *txConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err := p.PrepareContext(ctx, query)
+		return stmt, c.check(err)
+	}
+	stmt, err := c.Conn.Prepare(query)
+	return stmt, c.check(err)
+}
+
+func (c *txConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	res, err := execer.ExecContext(ctx, query, args)
+	return res, c.check(err)
+}
+
+func (c *txConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := queryer.QueryContext(ctx, query, args)
+	return rows, c.check(err)
+}
+
+func (c *txConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return c.check(p.Ping(ctx))
+	}
+	return nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker. driver.ErrSkip
+// makes database/sql fall back to its default conversion, as it would
+// for a driver without a checker
+func (c *txConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// txSavepoint is a nested transaction
+type txSavepoint struct {
+	conn *txConn
+	name string
+}
+
+func (tx *txSavepoint) Commit() error {
+	return tx.conn.exec(context.Background(), "RELEASE SAVEPOINT "+tx.name)
+}
+
+func (tx *txSavepoint) Rollback() error {
+	return tx.conn.exec(context.Background(), "ROLLBACK TO SAVEPOINT "+tx.name)
+}
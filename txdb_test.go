@@ -0,0 +1,184 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingConn is a driver.Conn that records the statements it executes
+type recordingConn struct {
+	queries *[]string
+	closed  bool
+	broken  bool
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *recordingConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+func (c *recordingConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if c.broken {
+		return nil, driver.ErrBadConn
+	}
+	*c.queries = append(*c.queries, query)
+	return driver.RowsAffected(0), nil
+}
+
+type recordingConnector struct {
+	conns   int
+	last    *recordingConn
+	queries []string
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	c.conns++
+	c.last = &recordingConn{queries: &c.queries}
+	return c.last, nil
+}
+
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+func TestTxConnector(t *testing.T) {
+	base := &recordingConnector{}
+	connector := &txConnector{base: base}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+
+	tx, err := db.Begin()
+	if !assert.NoError(t, err, "Begin should succeed") {
+		return
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); !assert.NoError(t, err, "Exec should succeed") {
+		return
+	}
+	if !assert.NoError(t, tx.Commit(), "Commit should succeed") {
+		return
+	}
+
+	tx, err = db.Begin()
+	if !assert.NoError(t, err, "Begin should succeed") {
+		return
+	}
+	if !assert.NoError(t, tx.Rollback(), "Rollback should succeed") {
+		return
+	}
+
+	db.Close()
+	if !assert.False(t, base.last.closed, "connection stays open after db.Close") {
+		return
+	}
+	if !assert.NoError(t, connector.close(), "close should succeed") {
+		return
+	}
+
+	expected := []string{
+		"START TRANSACTION",
+		"SAVEPOINT mysqltest_1",
+		"INSERT INTO t VALUES (1)",
+		"RELEASE SAVEPOINT mysqltest_1",
+		"SAVEPOINT mysqltest_2",
+		"ROLLBACK TO SAVEPOINT mysqltest_2",
+		"ROLLBACK",
+	}
+	if !assert.Equal(t, expected, base.queries, "statements match") {
+		return
+	}
+	if !assert.Equal(t, 1, base.conns, "a single connection is used") {
+		return
+	}
+	if !assert.True(t, base.last.closed, "connection is closed") {
+		return
+	}
+}
+
+func TestTxConnectorBadConn(t *testing.T) {
+	base := &recordingConnector{}
+	connector := &txConnector{base: base}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO t VALUES (1)"); !assert.NoError(t, err, "Exec should succeed") {
+		return
+	}
+
+	first := base.last
+	first.broken = true
+	if _, err := db.Exec("INSERT INTO t VALUES (2)"); !assert.NoError(t, err, "Exec should succeed on a new connection") {
+		return
+	}
+	if !assert.True(t, first.closed, "broken connection is closed") {
+		return
+	}
+	if !assert.Equal(t, 2, base.conns, "a new connection is used") {
+		return
+	}
+
+	expected := []string{
+		"START TRANSACTION",
+		"INSERT INTO t VALUES (1)",
+		"START TRANSACTION",
+		"INSERT INTO t VALUES (2)",
+	}
+	if !assert.Equal(t, expected, base.queries, "statements match") {
+		return
+	}
+}
+
+func TestTxDB(t *testing.T) {
+	mysqld, err := NewMysqld(nil)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	db, err := mysqld.DB()
+	if !assert.NoError(t, err, "DB should succeed") {
+		return
+	}
+	if _, err := db.Exec("CREATE TABLE orders (id INT NOT NULL PRIMARY KEY)"); !assert.NoError(t, err, "Exec should succeed") {
+		return
+	}
+
+	txdb, cleanup, err := mysqld.TxDB()
+	if !assert.NoError(t, err, "TxDB should succeed") {
+		return
+	}
+
+	tx, err := txdb.Begin()
+	if !assert.NoError(t, err, "Begin should succeed") {
+		return
+	}
+	if _, err := tx.Exec("INSERT INTO orders VALUES (1)"); !assert.NoError(t, err, "Exec should succeed") {
+		return
+	}
+	if !assert.NoError(t, tx.Commit(), "Commit should succeed") {
+		return
+	}
+
+	var count int
+	if err := txdb.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count); !assert.NoError(t, err, "QueryRow should succeed") {
+		return
+	}
+	if !assert.Equal(t, 1, count, "committed row is visible inside the transaction") {
+		return
+	}
+
+	if !assert.NoError(t, cleanup(), "cleanup should succeed") {
+		return
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count); !assert.NoError(t, err, "QueryRow should succeed") {
+		return
+	}
+	if !assert.Equal(t, 0, count, "row is rolled back") {
+		return
+	}
+}
@@ -0,0 +1,278 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// User describes a database account to be created on the server
+type User struct {
+	Name     string
+	Password string
+
+	// Host is the host part of the account. If empty, "localhost" is
+	// used when TCP is disabled, and "%" otherwise
+	Host string
+
+	// Plugin is the authentication plugin, such as
+	// "mysql_native_password" or "caching_sha2_password".
+	// If empty, the server default is used
+	Plugin string
+
+	// Grants is a list of privileges in the form "PRIVILEGES ON object",
+	// such as "SELECT, INSERT ON test.*"
+	Grants []string
+
+	// Roles is a list of roles (MySQL 8.0 and later) to be granted to
+	// and activated by default for this user
+	Roles []string
+}
+
+// Role describes a role (MySQL 8.0 and later) to be created on the server
+type Role struct {
+	Name   string
+	Grants []string
+}
+
+// userKey identifies an account: 'app'@'%' and 'app'@'localhost' are
+// different accounts
+type userKey struct {
+	name string
+	host string
+}
+
+// noBackslashEscapes is the sql_mode for connections that execute
+// statements built with quoteString
+const noBackslashEscapes = "CONCAT_WS(',',NULLIF(@@sql_mode,''),'NO_BACKSLASH_ESCAPES')"
+
+var pluginName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// quoteString quotes s as a string literal. Backslashes are not
+// escaped, so the statement must be executed with NO_BACKSLASH_ESCAPES
+// in sql_mode, as connections returned by openRoot are
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, `'`, `''`, -1) + "'"
+}
+
+func (m *TestMysqld) userHost(u *User) string {
+	if u.Host != "" {
+		return u.Host
+	}
+	if !m.hasTCP() {
+		return "localhost"
+	}
+	return "%"
+}
+
+func (m *TestMysqld) openRoot() (*sql.DB, error) {
+	db, err := sql.Open("mysql", m.DSN(WithDbname("mysql"), WithUser("root"), WithParam("sql_mode", noBackslashEscapes)))
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to connect to database`)
+	}
+	return db, nil
+}
+
+// CreateRole creates a role and grants it the specified privileges.
+// Roles are only supported by MySQL 8.0 and later
+func (m *TestMysqld) CreateRole(r *Role) error {
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return createRole(db, r)
+}
+
+func createRole(db *sql.DB, r *Role) error {
+	stmt := "CREATE ROLE IF NOT EXISTS " + quoteString(r.Name)
+	if _, err := db.Exec(stmt); err != nil {
+		return errors.Wrapf(err, `failed to create role %s`, r.Name)
+	}
+
+	for _, grant := range r.Grants {
+		stmt := fmt.Sprintf("GRANT %s TO %s", grant, quoteString(r.Name))
+		if _, err := db.Exec(stmt); err != nil {
+			return errors.Wrapf(err, `failed to execute '%s'`, stmt)
+		}
+	}
+	return nil
+}
+
+// CreateUser creates a user with the given password and privileges,
+// and returns a DSN to connect as that user. Each grant is in the form
+// "PRIVILEGES ON object", such as "SELECT, INSERT ON test.*"
+func (m *TestMysqld) CreateUser(name, password string, grants ...string) (string, error) {
+	return m.ProvisionUser(&User{
+		Name:     name,
+		Password: password,
+		Grants:   grants,
+	})
+}
+
+// ProvisionUser creates the user described by u if it does not exist
+// yet, grants it the privileges and roles, and returns a DSN to
+// connect as that user
+func (m *TestMysqld) ProvisionUser(u *User) (string, error) {
+	db, err := m.openRoot()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if err := m.provisionUser(db, u); err != nil {
+		return "", err
+	}
+	return m.UserDSN(u.Name), nil
+}
+
+func (m *TestMysqld) provisionUser(db *sql.DB, u *User) error {
+	host := m.userHost(u)
+	account := quoteString(u.Name) + "@" + quoteString(host)
+
+	if u.Plugin != "" && !pluginName.MatchString(u.Plugin) {
+		return errors.Errorf(`invalid authentication plugin name %s`, u.Plugin)
+	}
+
+	// CREATE USER IF NOT EXISTS is not available before 5.7
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM mysql.user WHERE user = ? AND host = ?", u.Name, host).Scan(&count); err != nil {
+		return errors.Wrapf(err, `failed to check for user %s`, account)
+	}
+
+	if count == 0 {
+		stmt := "CREATE USER " + account
+		switch {
+		case u.Plugin != "" && u.Password != "":
+			stmt += " IDENTIFIED WITH " + u.Plugin + " BY " + quoteString(u.Password)
+		case u.Plugin != "":
+			stmt += " IDENTIFIED WITH " + u.Plugin
+		case u.Password != "":
+			stmt += " IDENTIFIED BY " + quoteString(u.Password)
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			return errors.Wrapf(err, `failed to create user %s`, account)
+		}
+	}
+
+	for _, grant := range u.Grants {
+		stmt := fmt.Sprintf("GRANT %s TO %s", grant, account)
+		if _, err := db.Exec(stmt); err != nil {
+			return errors.Wrapf(err, `failed to execute '%s'`, stmt)
+		}
+	}
+
+	if len(u.Roles) > 0 {
+		roles := make([]string, len(u.Roles))
+		for i, role := range u.Roles {
+			roles[i] = quoteString(role)
+		}
+
+		for _, stmt := range []string{
+			fmt.Sprintf("GRANT %s TO %s", strings.Join(roles, ", "), account),
+			fmt.Sprintf("SET DEFAULT ROLE ALL TO %s", account),
+		} {
+			if _, err := db.Exec(stmt); err != nil {
+				return errors.Wrapf(err, `failed to execute '%s'`, stmt)
+			}
+		}
+	}
+
+	m.usersMu.Lock()
+	if m.users == nil {
+		m.users = make(map[userKey]*User)
+	}
+	m.users[userKey{name: u.Name, host: host}] = u
+	m.usersMu.Unlock()
+	return nil
+}
+
+// DropUser drops the account name@host, which was created via
+// CreateUser, ProvisionUser, or config.Users. If host is empty, the
+// same default as User.Host is used
+func (m *TestMysqld) DropUser(name, host string) error {
+	host = m.userHost(&User{Host: host})
+	account := quoteString(name) + "@" + quoteString(host)
+
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DROP USER " + account); err != nil {
+		return errors.Wrapf(err, `failed to drop user %s`, account)
+	}
+
+	m.usersMu.Lock()
+	delete(m.users, userKey{name: name, host: host})
+	m.usersMu.Unlock()
+	return nil
+}
+
+// lookupUser returns the account with the given name. If there are
+// several, the one with the default host is preferred
+func (m *TestMysqld) lookupUser(name string) *User {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	if u, ok := m.users[userKey{name: name, host: m.userHost(&User{})}]; ok {
+		return u
+	}
+
+	var hosts []string
+	for key := range m.users {
+		if key.name == name {
+			hosts = append(hosts, key.host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	sort.Strings(hosts)
+	return m.users[userKey{name: name, host: hosts[0]}]
+}
+
+// provision creates the roles and users listed in the configuration
+func (m *TestMysqld) provision() error {
+	config := m.Config
+	if len(config.Roles) == 0 && len(config.Users) == 0 {
+		return nil
+	}
+
+	db, err := m.openRoot()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, r := range config.Roles {
+		if err := createRole(db, r); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range config.Users {
+		if err := m.provisionUser(db, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserDSN creates a DSN to connect as a user that was created via
+// CreateUser, ProvisionUser, or config.Users. If there are several
+// accounts with that name, the password of the one with the default
+// host is used. Additional options are passed to DSN
+func (m *TestMysqld) UserDSN(name string, options ...DatasourceOption) string {
+	list := []DatasourceOption{WithUser(name)}
+	if u := m.lookupUser(name); u != nil && u.Password != "" {
+		list = append(list, WithPassword(u.Password))
+	}
+	return m.DSN(append(list, options...)...)
+}
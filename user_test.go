@@ -0,0 +1,127 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteString(t *testing.T) {
+	if !assert.Equal(t, `'it''s a \ test'`, quoteString(`it's a \ test`), "quoted string matches") {
+		return
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	config := NewConfig()
+	config.Users = []*User{
+		{Name: "reader", Password: "r3ad3r", Grants: []string{"SELECT ON test.*"}},
+	}
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	writerDSN, err := mysqld.CreateUser("writer", "wr1t3r", "ALL ON test.*")
+	if !assert.NoError(t, err, "CreateUser should succeed") {
+		return
+	}
+
+	writer, err := sql.Open("mysql", writerDSN)
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer writer.Close()
+
+	if _, err := writer.Exec("CREATE TABLE greetings (id INT NOT NULL PRIMARY KEY)"); !assert.NoError(t, err, "writer should be able to create tables") {
+		return
+	}
+
+	reader, err := sql.Open("mysql", mysqld.UserDSN("reader"))
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer reader.Close()
+
+	var count int
+	if !assert.NoError(t, reader.QueryRow("SELECT COUNT(*) FROM greetings").Scan(&count), "reader should be able to select") {
+		return
+	}
+
+	_, err = reader.Exec("INSERT INTO greetings VALUES (1)")
+	if !assert.Error(t, err, "reader should not be able to insert") {
+		return
+	}
+
+	merr, ok := err.(*mysql.MySQLError)
+	if !assert.True(t, ok, "error should be a *mysql.MySQLError") {
+		return
+	}
+	if !assert.Equal(t, uint16(1142), merr.Number, "error should be ER_TABLEACCESS_DENIED_ERROR") {
+		return
+	}
+}
+
+func TestUserAccounts(t *testing.T) {
+	config := NewConfig()
+	config.SkipNetworking = false
+	config.Users = []*User{
+		{Name: "app", Password: "local", Host: "localhost"},
+		{Name: "app", Password: "remote", Host: "%"},
+	}
+
+	mysqld, err := NewMysqld(config)
+	if !assert.NoError(t, err, "NewMysqld should succeed") {
+		return
+	}
+	defer mysqld.Stop()
+
+	if !assert.Contains(t, mysqld.UserDSN("app"), ":remote@", "password of the default host is used") {
+		return
+	}
+
+	if !assert.NoError(t, mysqld.DropUser("app", "localhost"), "DropUser should succeed") {
+		return
+	}
+
+	db, err := mysqld.openRoot()
+	if !assert.NoError(t, err, "openRoot should succeed") {
+		return
+	}
+	defer db.Close()
+
+	hosts, err := queryStrings(db, "SELECT host FROM mysql.user WHERE user = 'app'")
+	if !assert.NoError(t, err, "query should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"%"}, hosts, "only app@localhost is dropped") {
+		return
+	}
+
+	t.Run("Passwords with quotes and backslashes", func(t *testing.T) {
+		dsn, err := mysqld.ProvisionUser(&User{Name: "quoted", Password: `it's a \ test`, Host: "%"})
+		if !assert.NoError(t, err, "ProvisionUser should succeed") {
+			return
+		}
+		quoted, err := sql.Open("mysql", dsn)
+		if !assert.NoError(t, err, "sql.Open should succeed") {
+			return
+		}
+		defer quoted.Close()
+
+		if !assert.NoError(t, quoted.Ping(), "Ping should succeed") {
+			return
+		}
+	})
+
+	t.Run("Invalid plugin name", func(t *testing.T) {
+		_, err := mysqld.ProvisionUser(&User{Name: "bad", Plugin: "mysql_native_password BY 'x'"})
+		if !assert.Error(t, err, "ProvisionUser should fail") {
+			return
+		}
+	})
+}
@@ -0,0 +1,554 @@
+package mysqltest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MySQL protocol constants used by InterceptingProxy
+const (
+	comInitDB      = 0x02
+	comQuery       = 0x03
+	comStmtPrepare = 0x16
+	comStmtExecute = 0x17
+	comStmtClose   = 0x19
+
+	clientCompress           = 0x00000020
+	clientConnectWithDB      = 0x00000008
+	clientSSL                = 0x00000800
+	clientSecureConnection   = 0x00008000
+	clientPluginAuthLenencCD = 0x00200000
+)
+
+// Error codes commonly used with InterceptingProxy.InjectError
+const (
+	ErrLockWaitTimeout = 1205
+	ErrLockDeadlock    = 1213
+)
+
+type interceptAction int
+
+const (
+	interceptError interceptAction = iota
+	interceptDisconnect
+	interceptDelay
+)
+
+type interceptRule struct {
+	pattern  *regexp.Regexp
+	action   interceptAction
+	code     uint16
+	sqlState string
+	message  string
+	delay    time.Duration
+}
+
+// InterceptingProxy is a proxy that understands enough of the MySQL
+// client/server protocol to record the statements sent by clients,
+// and to inject errors, disconnections, or delays for statements
+// matching a pattern. Create one via TestMysqld.InterceptingProxy.
+//
+// Connections through the proxy cannot use TLS or compression.
+type InterceptingProxy struct {
+	mu       sync.Mutex
+	listener net.Listener
+	network  string
+	address  string
+	mysqld   *TestMysqld
+	conns    map[*interceptConn]struct{}
+	rules    []*interceptRule
+	queries  Queries
+	closed   bool
+}
+
+type interceptConn struct {
+	proxy  *InterceptingProxy
+	client net.Conn
+	server net.Conn
+
+	// wmu serializes writes to the client, which may come from either
+	// the server (responses) or the proxy itself (injected errors)
+	wmu sync.Mutex
+
+	mu             sync.Mutex
+	id             int64
+	user           string
+	database       string
+	stmts          map[uint32]string
+	pendingPrepare string
+}
+
+// InterceptingProxy starts a new protocol-aware proxy listening on a
+// random port on 127.0.0.1, which forwards connections to the mysqld
+// instance. The proxy is closed when Stop is called
+func (m *TestMysqld) InterceptingProxy() (*InterceptingProxy, error) {
+	network, address := m.serverAddr()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to listen for proxy`)
+	}
+
+	p := &InterceptingProxy{
+		listener: l,
+		network:  network,
+		address:  address,
+		mysqld:   m,
+		conns:    make(map[*interceptConn]struct{}),
+	}
+	m.addGuard(func() { p.Close() })
+
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on
+func (p *InterceptingProxy) Addr() *net.TCPAddr {
+	return p.listener.Addr().(*net.TCPAddr)
+}
+
+// DSN creates a datasource name string that connects to mysqld
+// through the proxy. TLS is always disabled, as the proxy needs to
+// read the traffic. Options are otherwise handled as in TestMysqld.DSN
+func (p *InterceptingProxy) DSN(options ...DatasourceOption) string {
+	addr := p.Addr()
+	list := []DatasourceOption{
+		WithProto("tcp"),
+		WithHost(addr.IP.String()),
+		WithPort(addr.Port),
+		WithTLS("false"),
+	}
+	return p.mysqld.DSN(append(list, options...)...)
+}
+
+func (p *InterceptingProxy) addRule(pattern string, r *interceptRule) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.Wrap(err, `failed to compile pattern`)
+	}
+	r.pattern = re
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(p.rules, r)
+	return nil
+}
+
+// InjectError makes the proxy respond with the given server error to
+// statements matching the regular expression pattern, instead of
+// forwarding them to mysqld
+func (p *InterceptingProxy) InjectError(pattern string, code uint16, sqlState, message string) error {
+	return p.addRule(pattern, &interceptRule{
+		action:   interceptError,
+		code:     code,
+		sqlState: sqlState,
+		message:  message,
+	})
+}
+
+// InjectDeadlock makes statements matching pattern fail with
+// ER_LOCK_DEADLOCK (1213)
+func (p *InterceptingProxy) InjectDeadlock(pattern string) error {
+	return p.InjectError(pattern, ErrLockDeadlock, "40001", "Deadlock found when trying to get lock; try restarting transaction")
+}
+
+// InjectLockWaitTimeout makes statements matching pattern fail with
+// ER_LOCK_WAIT_TIMEOUT (1205)
+func (p *InterceptingProxy) InjectLockWaitTimeout(pattern string) error {
+	return p.InjectError(pattern, ErrLockWaitTimeout, "HY000", "Lock wait timeout exceeded; try restarting transaction")
+}
+
+// InjectDisconnect makes the proxy close the connection when a
+// statement matching pattern is sent. The mysql command line client
+// reports this as CR_SERVER_LOST (2013), while go-sql-driver returns
+// mysql.ErrInvalidConn ("invalid connection"), and discards the
+// connection. database/sql does not retry in that case, as the
+// statement may have been executed. It only retries on another
+// connection when the driver returns driver.ErrBadConn, which happens
+// when a pooled connection was already closed before the statement
+// could be sent; since the rule stays in place, the retry is
+// disconnected too
+func (p *InterceptingProxy) InjectDisconnect(pattern string) error {
+	return p.addRule(pattern, &interceptRule{action: interceptDisconnect})
+}
+
+// InjectDelay makes the proxy hold statements matching pattern for d
+// before forwarding them to mysqld
+func (p *InterceptingProxy) InjectDelay(pattern string, d time.Duration) error {
+	return p.addRule(pattern, &interceptRule{action: interceptDelay, delay: d})
+}
+
+// ClearRules removes all rules registered via the Inject* methods
+func (p *InterceptingProxy) ClearRules() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = nil
+}
+
+// Queries returns the statements sent through the proxy so far.
+// Command is one of "Query", "Prepare", or "Execute". For "Execute",
+// Statement is the text of the prepared statement
+func (p *InterceptingProxy) Queries() Queries {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make(Queries, len(p.queries))
+	copy(list, p.queries)
+	return list
+}
+
+// ResetQueries clears the list of statements returned by Queries
+func (p *InterceptingProxy) ResetQueries() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queries = nil
+}
+
+// Close stops accepting new connections and closes all active ones
+func (p *InterceptingProxy) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[*interceptConn]struct{})
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	for c := range conns {
+		c.close()
+	}
+	return err
+}
+
+func (p *InterceptingProxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server, err := net.Dial(p.network, p.address)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		c := &interceptConn{
+			proxy:  p,
+			client: client,
+			server: server,
+			stmts:  make(map[uint32]string),
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			c.close()
+			return
+		}
+		p.conns[c] = struct{}{}
+		p.mu.Unlock()
+
+		go c.fromClient()
+		go c.fromServer()
+	}
+}
+
+func (p *InterceptingProxy) match(stmt string) *interceptRule {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.rules {
+		if r.pattern.MatchString(stmt) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (p *InterceptingProxy) record(q *Query) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queries = append(p.queries, q)
+}
+
+func (c *interceptConn) close() {
+	c.client.Close()
+	c.server.Close()
+
+	p := c.proxy
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+}
+
+// readPacket reads a single packet, and returns its sequence id and payload
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[3], payload, nil
+}
+
+func writePacket(w io.Writer, seq byte, payload []byte) error {
+	length := len(payload)
+	buf := make([]byte, 4+length)
+	buf[0] = byte(length)
+	buf[1] = byte(length >> 8)
+	buf[2] = byte(length >> 16)
+	buf[3] = seq
+	copy(buf[4:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func errPacket(code uint16, sqlState, message string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(0xff)
+	binary.Write(&buf, binary.LittleEndian, code)
+	buf.WriteByte('#')
+	state := []byte(sqlState + "HY000")[:5]
+	buf.Write(state)
+	buf.WriteString(message)
+	return buf.Bytes()
+}
+
+func (c *interceptConn) writeClient(seq byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writePacket(c.client, seq, payload)
+}
+
+func (c *interceptConn) fromServer() {
+	defer c.close()
+
+	greeted := false
+	for {
+		seq, payload, err := readPacket(c.server)
+		if err != nil {
+			return
+		}
+
+		if !greeted {
+			greeted = true
+			c.handleGreeting(payload)
+		} else if seq == 1 {
+			// First packet of a response. If it is the response to
+			// COM_STMT_PREPARE, remember the statement id
+			c.mu.Lock()
+			if c.pendingPrepare != "" && len(payload) >= 5 && payload[0] == 0x00 {
+				c.stmts[binary.LittleEndian.Uint32(payload[1:5])] = c.pendingPrepare
+			}
+			c.pendingPrepare = ""
+			c.mu.Unlock()
+		}
+
+		if err := c.writeClient(seq, payload); err != nil {
+			return
+		}
+	}
+}
+
+// handleGreeting records the connection id from the initial handshake
+// packet, and hides the TLS and compression capabilities from the
+// client so that the rest of the traffic can be read
+func (c *interceptConn) handleGreeting(payload []byte) {
+	// protocol version (1), server version (NUL terminated)
+	i := bytes.IndexByte(payload, 0x00)
+	if i < 0 || len(payload) < i+1+4+8+1+2 {
+		return
+	}
+	pos := i + 1
+
+	c.mu.Lock()
+	c.id = int64(binary.LittleEndian.Uint32(payload[pos:]))
+	c.mu.Unlock()
+
+	// connection id (4), auth-plugin-data-part-1 (8), filler (1)
+	pos += 4 + 8 + 1
+	flags := binary.LittleEndian.Uint16(payload[pos:])
+	flags &^= clientSSL | clientCompress
+	binary.LittleEndian.PutUint16(payload[pos:], flags)
+}
+
+// handleHandshakeResponse records the user name and initial database
+// from the client's HandshakeResponse41 packet
+func (c *interceptConn) handleHandshakeResponse(payload []byte) {
+	// capability flags (4), max packet size (4), character set (1), filler (23)
+	if len(payload) < 32 {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(payload)
+	rest := payload[32:]
+
+	i := bytes.IndexByte(rest, 0x00)
+	if i < 0 {
+		return
+	}
+	user := string(rest[:i])
+	rest = rest[i+1:]
+
+	// Skip the auth response
+	switch {
+	case flags&clientPluginAuthLenencCD != 0:
+		n, size := readLengthEncodedInt(rest)
+		if size == 0 || len(rest) < size+int(n) {
+			return
+		}
+		rest = rest[size+int(n):]
+	case flags&clientSecureConnection != 0:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return
+		}
+		rest = rest[1+int(rest[0]):]
+	default:
+		i := bytes.IndexByte(rest, 0x00)
+		if i < 0 {
+			return
+		}
+		rest = rest[i+1:]
+	}
+
+	var database string
+	if flags&clientConnectWithDB != 0 {
+		if i := bytes.IndexByte(rest, 0x00); i > -1 {
+			database = string(rest[:i])
+		}
+	}
+
+	c.mu.Lock()
+	c.user = user
+	c.database = database
+	c.mu.Unlock()
+}
+
+func readLengthEncodedInt(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	switch b[0] {
+	case 0xfc:
+		if len(b) < 3 {
+			return 0, 0
+		}
+		return uint64(binary.LittleEndian.Uint16(b[1:])), 3
+	case 0xfd:
+		if len(b) < 4 {
+			return 0, 0
+		}
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, 4
+	case 0xfe:
+		if len(b) < 9 {
+			return 0, 0
+		}
+		return binary.LittleEndian.Uint64(b[1:]), 9
+	default:
+		return uint64(b[0]), 1
+	}
+}
+
+func (c *interceptConn) fromClient() {
+	defer c.close()
+
+	handshaken := false
+	for {
+		seq, payload, err := readPacket(c.client)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case !handshaken:
+			handshaken = true
+			c.handleHandshakeResponse(payload)
+		case seq == 0 && len(payload) > 0:
+			// Commands always start with sequence id 0
+			if !c.handleCommand(payload) {
+				continue
+			}
+		}
+
+		if err := writePacket(c.server, seq, payload); err != nil {
+			return
+		}
+	}
+}
+
+// handleCommand inspects a command packet sent by the client, and
+// returns false if it should not be forwarded to the server
+func (c *interceptConn) handleCommand(payload []byte) bool {
+	var command, stmt string
+
+	c.mu.Lock()
+	switch payload[0] {
+	case comInitDB:
+		c.database = string(payload[1:])
+	case comQuery:
+		command, stmt = "Query", string(payload[1:])
+		// Track USE like CaptureQueries does, so that Database
+		// follows the client
+		if m := useStatement.FindStringSubmatch(stmt); m != nil {
+			c.database = unquoteIdentifier(m[1])
+		}
+	case comStmtPrepare:
+		command, stmt = "Prepare", string(payload[1:])
+	case comStmtExecute:
+		if len(payload) >= 5 {
+			command, stmt = "Execute", c.stmts[binary.LittleEndian.Uint32(payload[1:5])]
+		}
+	case comStmtClose:
+		if len(payload) >= 5 {
+			delete(c.stmts, binary.LittleEndian.Uint32(payload[1:5]))
+		}
+	}
+	q := &Query{
+		Time:         time.Now(),
+		ConnectionID: c.id,
+		User:         c.user,
+		Database:     c.database,
+		Command:      command,
+		Statement:    stmt,
+	}
+	c.mu.Unlock()
+
+	if command == "" {
+		return true
+	}
+
+	p := c.proxy
+	p.record(q)
+
+	if r := p.match(stmt); r != nil {
+		switch r.action {
+		case interceptDelay:
+			time.Sleep(r.delay)
+		case interceptDisconnect:
+			c.close()
+			return false
+		case interceptError:
+			c.writeClient(1, errPacket(r.code, r.sqlState, r.message))
+			return false
+		}
+	}
+
+	if command == "Prepare" {
+		c.mu.Lock()
+		c.pendingPrepare = stmt
+		c.mu.Unlock()
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package mysqltest
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeServer starts a server that speaks just enough of the MySQL
+// protocol to send a greeting, and respond with OK to every packet
+func startFakeServer(t *testing.T) (*TestMysqld, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				greeting := []byte{10}
+				greeting = append(greeting, "5.7.0-fake\x00"...)
+				greeting = append(greeting, 42, 0, 0, 0)            // connection id
+				greeting = append(greeting, "abcdefgh\x00"...)      // auth-plugin-data-part-1, filler
+				greeting = append(greeting, 0xff, 0xff, 0x21, 2, 0) // capabilities, charset, status
+				greeting = append(greeting, 0x08, 0, 21)            // capabilities (CLIENT_PLUGIN_AUTH), auth-plugin-data length
+				greeting = append(greeting, make([]byte, 10)...)    // reserved
+				greeting = append(greeting, "ijklmnopqrst\x00"...)  // auth-plugin-data-part-2
+				greeting = append(greeting, "mysql_native_password\x00"...)
+				if err := writePacket(conn, 0, greeting); err != nil {
+					return
+				}
+
+				for {
+					seq, _, err := readPacket(conn)
+					if err != nil {
+						return
+					}
+					if err := writePacket(conn, seq+1, []byte{0, 0, 0, 2, 0, 0, 0}); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	m := &TestMysqld{
+		Config: &MysqldConfig{
+			BindAddress: addr.IP.String(),
+			Port:        addr.Port,
+		},
+	}
+	return m, func() { l.Close() }
+}
+
+func TestInterceptingProxy(t *testing.T) {
+	m, cleanup := startFakeServer(t)
+	defer cleanup()
+
+	p, err := m.InterceptingProxy()
+	if !assert.NoError(t, err, "InterceptingProxy should succeed") {
+		return
+	}
+	defer p.Close()
+
+	if !assert.NoError(t, p.InjectDeadlock(`^UPDATE`), "InjectDeadlock should succeed") {
+		return
+	}
+
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if !assert.NoError(t, err, "Dial should succeed") {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, greeting, err := readPacket(conn)
+	if !assert.NoError(t, err, "reading greeting should succeed") {
+		return
+	}
+	// protocol version (1), server version (11), connection id (4),
+	// auth-plugin-data-part-1 (8), filler (1)
+	flags := binary.LittleEndian.Uint16(greeting[25:])
+	if !assert.Zero(t, flags&(clientSSL|clientCompress), "SSL and compression should be disabled") {
+		return
+	}
+
+	// HandshakeResponse41 with CLIENT_SECURE_CONNECTION | CLIENT_CONNECT_WITH_DB
+	response := make([]byte, 32)
+	binary.LittleEndian.PutUint32(response, clientSecureConnection|clientConnectWithDB)
+	response = append(response, "app\x00"...)
+	response = append(response, 0)
+	response = append(response, "test\x00"...)
+	if !assert.NoError(t, writePacket(conn, 1, response), "writing handshake response should succeed") {
+		return
+	}
+	if _, _, err := readPacket(conn); !assert.NoError(t, err, "reading OK should succeed") {
+		return
+	}
+
+	query := func(stmt string) []byte {
+		if err := writePacket(conn, 0, append([]byte{comQuery}, stmt...)); err != nil {
+			t.Fatalf("failed to write query: %s", err)
+		}
+		_, payload, err := readPacket(conn)
+		if err != nil {
+			t.Fatalf("failed to read response: %s", err)
+		}
+		return payload
+	}
+
+	if !assert.Equal(t, byte(0x00), query("SELECT 1")[0], "SELECT should succeed") {
+		return
+	}
+
+	res := query("UPDATE foo SET bar = 1")
+	if !assert.Equal(t, byte(0xff), res[0], "UPDATE should fail") {
+		return
+	}
+	if !assert.Equal(t, uint16(ErrLockDeadlock), binary.LittleEndian.Uint16(res[1:]), "error code matches") {
+		return
+	}
+	if !assert.Equal(t, "#40001", string(res[3:9]), "sql state matches") {
+		return
+	}
+
+	if !assert.Equal(t, byte(0x00), query("USE `app``db`")[0], "USE should succeed") {
+		return
+	}
+	query("SELECT 2")
+
+	queries := p.Queries()
+	if !assert.Len(t, queries, 4, "should record 4 queries") {
+		return
+	}
+	if !assert.Equal(t, "app`db", queries[3].Database, "USE is tracked") {
+		return
+	}
+	expected := &Query{
+		Time:         queries[0].Time,
+		ConnectionID: 42,
+		User:         "app",
+		Database:     "test",
+		Command:      "Query",
+		Statement:    "SELECT 1",
+	}
+	if !assert.Equal(t, expected, queries[0], "recorded query matches") {
+		return
+	}
+}
+
+func TestInjectDisconnect(t *testing.T) {
+	m, cleanup := startFakeServer(t)
+	defer cleanup()
+
+	p, err := m.InterceptingProxy()
+	if !assert.NoError(t, err, "InterceptingProxy should succeed") {
+		return
+	}
+	defer p.Close()
+
+	if !assert.NoError(t, p.InjectDisconnect(`^UPDATE`), "InjectDisconnect should succeed") {
+		return
+	}
+
+	db, err := sql.Open("mysql", p.DSN(WithTimeout(5*time.Second), WithReadTimeout(5*time.Second)))
+	if !assert.NoError(t, err, "sql.Open should succeed") {
+		return
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DELETE FROM foo"); !assert.NoError(t, err, "DELETE should succeed") {
+		return
+	}
+
+	// The statement has been sent, so database/sql does not retry it
+	_, err = db.Exec("UPDATE foo SET bar = 1")
+	if !assert.Equal(t, mysql.ErrInvalidConn, err, "UPDATE should fail with ErrInvalidConn") {
+		return
+	}
+
+	// The broken connection is discarded
+	if _, err := db.Exec("DELETE FROM foo"); !assert.NoError(t, err, "DELETE should succeed on a new connection") {
+		return
+	}
+	if !assert.Len(t, p.Queries().Match(`^UPDATE`), 1, "UPDATE should be sent once") {
+		return
+	}
+}